@@ -1,9 +1,14 @@
 package soffa
 
 import (
+	"context"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/soffa-io/soffa-core-go/sentry"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/go-co-op/gocron"
@@ -14,18 +19,27 @@ import (
 	"github.com/soffa-io/soffa-core-go/h"
 	"github.com/soffa-io/soffa-core-go/http"
 	"github.com/soffa-io/soffa-core-go/log"
+	"github.com/soffa-io/soffa-core-go/saga"
 )
 
 type App struct {
-	Name             string
-	Version          string
-	router           *http.Router
-	cfg              *conf.Manager
-	dbManager        *db.Manager
-	broker           broker.Client
-	onReadyListeners []func()
-	scheduler        *Scheduler
-	args             map[string]interface{}
+	Name                string
+	Version             string
+	router              *http.Router
+	cfg                 *conf.Manager
+	dbManager           *db.Manager
+	broker              broker.Client
+	outboxRelay         *db.OutboxRelay
+	sagas               *saga.Manager
+	onReadyListeners    []func()
+	onShutdownListeners []func()
+	scheduler           *Scheduler
+	shutdownTimeout     time.Duration
+	healthContributors  []HealthContributor
+	healthTimeout       time.Duration
+	started             int32
+	ready               int32
+	args                map[string]interface{}
 }
 
 func NewApp(cfg *conf.Manager, name string, version string) *App {
@@ -34,11 +48,13 @@ func NewApp(cfg *conf.Manager, name string, version string) *App {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	a := &App{
-		cfg:       cfg,
-		Name:      name,
-		scheduler: &Scheduler{s: gocron.NewScheduler(time.UTC), empty: true},
-		Version:   version,
-		args:      map[string]interface{}{},
+		cfg:             cfg,
+		Name:            name,
+		scheduler:       &Scheduler{s: gocron.NewScheduler(time.UTC), empty: true},
+		Version:         version,
+		shutdownTimeout: cfg.GetDuration(30*time.Second, "shutdown.timeout", "SHUTDOWN_TIMEOUT"),
+		healthTimeout:   cfg.GetDuration(5*time.Second, "health.timeout", "HEALTH_TIMEOUT"),
+		args:            map[string]interface{}{},
 	}
 	return a
 }
@@ -53,15 +69,17 @@ func (a *App) UseDB(cb func(m *db.Manager)) *App {
 		a.dbManager = db.NewManager(a.Name)
 	}
 	cb(a.dbManager)
+	a.publishTenantEvents()
 	return a
 }
 
 func (a *App) UseBroker(cb func(client broker.Client)) *App {
 	if a.broker == nil {
 		brokerUrl := a.cfg.Require("broker.url", "BROKER_URL", "MESSAGE_BROKER_URL")
-		a.broker = broker.NewClient(brokerUrl, a.Name)
+		a.broker = broker.NewClientWithConfig(brokerUrl, a.Name, broker.LoadConfig(a.cfg))
 	}
 	cb(a.broker)
+	a.publishTenantEvents()
 	return a
 }
 
@@ -74,6 +92,10 @@ func (a *App) Configure(cb func(router *http.Router, scheduler *Scheduler)) *App
 			Handler: a.handleHealthCheck,
 			//Open:    true,
 		})
+		a.router.GET("/health/live", a.handleLiveness)
+		a.router.GET("/health/ready", a.handleReadiness)
+		a.router.GET("/health/startup", a.handleStartup)
+		a.router.ResolveTenant(http.TenantStrategy(h.AnyStr(a.cfg.Get("tenant.strategy", "TENANT_STRATEGY"), "header")))
 
 	}
 	cb(a.router, a.scheduler)
@@ -88,20 +110,58 @@ func (a *App) AddStartupListener(fn func()) *App {
 	return a
 }
 
+func (a *App) AddShutdownListener(fn func()) *App {
+	if a.onShutdownListeners == nil {
+		a.onShutdownListeners = []func(){}
+	}
+	a.onShutdownListeners = append(a.onShutdownListeners, fn)
+	return a
+}
+
+func (a *App) SetShutdownTimeout(timeout time.Duration) *App {
+	a.shutdownTimeout = timeout
+	return a
+}
+
 func (a *App) MigrateDB() {
 	if a.dbManager != nil {
 		a.dbManager.Migrate()
 	}
 }
 
+func (a *App) MigrationStatus() []db.MigrationStatus {
+	if a.dbManager == nil {
+		return nil
+	}
+	return a.dbManager.Status()
+}
+
+func (a *App) DryRunMigrations() []db.PendingMigration {
+	if a.dbManager == nil {
+		return nil
+	}
+	return a.dbManager.DryRun()
+}
+
+// RollbackDB undoes the last migration of the datasource ds (all when empty),
+// or the migrations applied after to.
+func (a *App) RollbackDB(ds string, to string) {
+	if a.dbManager != nil {
+		a.dbManager.Rollback(ds, to)
+	}
+}
+
 func (a *App) bootstrap() {
 	a.printHealthCheck()
+	// the metrics of the first messages (in-flight gauge) must be exported
+	conf.PrometheusEnabled = true
 	if a.broker != nil {
 		a.broker.Start()
 	}
 	if a.dbManager != nil {
 		a.dbManager.Migrate()
 	}
+	a.startOutboxRelay()
 	if a.scheduler != nil {
 		a.scheduler.Start()
 	}
@@ -110,75 +170,66 @@ func (a *App) bootstrap() {
 			for _, l := range a.onReadyListeners {
 				l()
 			}
-			conf.PrometheusEnabled = true
+			a.setStarted()
 			log.Default.Info("All on-ready listeneres invoked.")
 		}()
+	} else {
+		a.setStarted()
 	}
 }
 
 func (a *App) Start(port int) {
 	a.bootstrap()
-	a.router.Start(port)
-}
+	a.router.Listen(port)
+	go a.router.Serve()
 
-type HealthCheck struct {
-	Name    string `json:"name"`
-	Status  string `json:"status"`
-	Message string `json:"message,omitempty"`
-}
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-quit
+	signal.Stop(quit)
 
-func (h HealthCheck) get(err error) HealthCheck {
-	if err != nil {
-		h.Status = "DOWN"
-		h.Message = err.Error()
-	} else {
-		h.Status = "UP"
-	}
-	return h
+	log.Default.Infof("%s received, shutting down %s", sig, a.Name)
+	a.Shutdown()
 }
 
-func (a *App) getHealthCheck() (bool, []HealthCheck) {
-	var comps []HealthCheck
+// Shutdown tears the application down in order: the http server stops accepting
+// traffic and drains in-flight requests, the outbox relay finishes its batch,
+// the broker drains its subscriptions,
+// the scheduler waits for running jobs, shutdown listeners are invoked and
+// finally every datasource is closed. All steps share the shutdown timeout.
+func (a *App) Shutdown() {
+	atomic.StoreInt32(&a.ready, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), a.shutdownTimeout)
+	defer cancel()
 
-	if a.dbManager != nil {
-		comps = append(comps, HealthCheck{
-			Name: "db",
-		}.get(a.dbManager.Ping()))
+	if a.router != nil {
+		log.Default.ErrorIf(a.router.Shutdown(ctx), "http server shutdown failed")
+	}
+	if a.outboxRelay != nil {
+		log.Default.ErrorIf(a.outboxRelay.Stop(ctx), "outbox relay shutdown failed")
 	}
-
 	if a.broker != nil {
-		comps = append(comps, HealthCheck{
-			Name: "broker",
-		}.get(a.broker.Ping()))
+		log.Default.ErrorIf(a.broker.Shutdown(ctx), "broker shutdown failed")
 	}
-
-	allUp := true
-	for _, hc := range comps {
-		if hc.Status == "DOWN" {
-			allUp = false
-			break
-		}
+	if a.scheduler != nil {
+		log.Default.ErrorIf(a.scheduler.Stop(ctx), "scheduler shutdown failed")
 	}
-
-	return allUp, comps
-}
-
-func (a *App) handleHealthCheck(c *http.Context) {
-	status := "UP"
-	allUp, checks := a.getHealthCheck()
-	if !allUp {
-		status = "DOWN"
+	for _, l := range a.onShutdownListeners {
+		a.invokeShutdownListener(l)
 	}
-	comps := map[string]HealthCheck{}
-	for _, c := range checks {
-		comps[c.Name] = c
+	if a.dbManager != nil {
+		log.Default.ErrorIf(a.dbManager.Close(), "datasources shutdown failed")
 	}
-	c.OK(h.Map{
-		"application": a.Name,
-		"version":     a.Version,
-		"status":      status,
-		"components":  comps,
-	})
+	log.Default.Infof("%s stopped", a.Name)
+}
+
+func (a *App) invokeShutdownListener(fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Default.Errorf("shutdown listener failed -- %v", r)
+		}
+	}()
+	fn()
 }
 
 func (a *App) printHealthCheck() {
@@ -189,7 +240,7 @@ func (a *App) printHealthCheck() {
 	fmt.Println("\nHealthchecks: ")
 	allUp, checks := a.getHealthCheck()
 	for _, hc := range checks {
-		if hc.Status == "UP" {
+		if hc.Status == StatusUp {
 
 			fmt.Printf("> %s: %s\n", hc.Name, hc.Status)
 		} else {
@@ -220,12 +271,37 @@ func (s *Scheduler) Start() {
 	}
 }
 
+// Stop prevents new job executions and waits for the running ones to finish,
+// or for ctx to expire.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	if s.empty {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		// gocron waits for the running jobs
+		s.s.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Default.Info("Job scheduler is stopped.")
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "running jobs did not complete in time")
+	}
+}
+
 func (s *Scheduler) Every(interval string, task func()) {
 	_, err := s.s.Every(interval).Do(func() {
 		defer func() {
 			if r := recover(); r != nil {
-				sentry.CaptureException(r.(error))
-				log.Default.Error("critital error from task execution -- %v", r)
+				err, ok := r.(error)
+				if !ok {
+					err = errors.Errorf("%v", r)
+				}
+				sentry.CaptureException(err)
+				log.Default.Errorf("critital error from task execution -- %v", r)
 			}
 		}()
 		task()
@@ -48,12 +48,41 @@ func (t *Tester) DB() *db.Manager {
 	return t.app.dbManager
 }
 
-func (t *Tester) Publish(subj string, data interface{}) error {
-	return t.app.broker.Publish(subj, data)
+// FlushOutbox publishes the pending outbox events right away and returns how
+// many were sent.
+func (t *Tester) FlushOutbox() int {
+	if t.app.outboxRelay == nil {
+		t.test.Fatal("outbox relay is not running (no datasource with Outbox enabled or no broker)")
+	}
+	return t.app.outboxRelay.Flush()
+}
+
+func (t *Tester) Publish(subj string, data interface{}, opts ...broker.PublishOption) error {
+	return t.app.broker.Publish(subj, data, opts...)
+}
+
+func (t *Tester) Subscribe(subj string, handler broker.Handler, opts ...broker.SubscribeOption) {
+	t.app.broker.Subscribe(subj, handler, opts...)
+}
+
+func (t *Tester) SubscribeQueue(subj string, group string, handler broker.Handler, opts ...broker.SubscribeOption) {
+	t.app.broker.SubscribeQueue(subj, group, handler, opts...)
+}
+
+// Published returns the messages the application published to the subjects
+// matching subject, it requires the mock broker.
+func (t *Tester) Published(subject string) []broker.Message {
+	client, ok := t.app.broker.(*broker.FakeRpcClient)
+	if !ok {
+		t.test.Fatal("published messages are only recorded by the mock broker (BROKER_URL=mock)")
+	}
+	return client.Published(subject)
 }
 
-func (t *Tester) Subscribe(subj string, handler broker.Handler) {
-	t.app.broker.Subscribe(subj, handler)
+func (t *Tester) AssertPublished(subject string, count int) []broker.Message {
+	messages := t.Published(subject)
+	assert.Len(t.test, messages, count, "messages published to %s", subject)
+	return messages
 }
 
 func (t *Tester) SubscribeAll(subj []string, handler broker.Handler) {
@@ -62,6 +91,11 @@ func (t *Tester) SubscribeAll(subj []string, handler broker.Handler) {
 	}
 }
 
+// Broker is the broker client of the application under test.
+func (t *Tester) Broker() broker.Client {
+	return t.app.broker
+}
+
 func (t *Tester) Close() {
 	t.server.Close()
 }
@@ -124,6 +158,11 @@ func (t *TestRequest) Query(key string, value interface{}) *TestRequest {
 	return t
 }
 
+func (t *TestRequest) Header(key string, value string) *TestRequest {
+	t.request.WithHeader(key, value)
+	return t
+}
+
 func (t *TestRequest) Expect() TestResponse {
 	return TestResponse{
 		response: t.request.Expect(),
@@ -192,11 +231,25 @@ func (t TestResponse) Forbidden() TestResponse {
 	return t
 }
 
+func (t TestResponse) Conflict() TestResponse {
+	t.response.Status(http.StatusConflict)
+	return t
+}
+
 func (t TestResponse) Status(status int) TestResponse {
 	t.response.Status(status)
 	return t
 }
 
+func (t TestResponse) Contains(text string) TestResponse {
+	t.response.Body().Contains(text)
+	return t
+}
+
+func (t TestResponse) Header(name string) string {
+	return t.response.Header(name).Raw()
+}
+
 func (t TestResponse) Json(path string) TestResult {
 	return TestResult{
 		value: t.response.JSON().Path(path),
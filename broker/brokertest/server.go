@@ -0,0 +1,134 @@
+// Package brokertest provides an in-process nats server for the tests of the
+// applications using the broker package.
+package brokertest
+
+import (
+	"fmt"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/soffa-io/soffa-core-go/errors"
+	"testing"
+	"time"
+)
+
+// EmbeddedServer is an in-process nats server meant for tests. It can be
+// stopped and restarted on the same port to simulate a broker outage.
+type EmbeddedServer struct {
+	opts   server.Options
+	server *server.Server
+}
+
+func RunEmbeddedServer() (*EmbeddedServer, error) {
+	return runEmbeddedServer(server.Options{})
+}
+
+// RunEmbeddedJetStreamServer starts a server with JetStream enabled, messages
+// are persisted in storeDir so they survive a restart.
+func RunEmbeddedJetStreamServer(storeDir string) (*EmbeddedServer, error) {
+	return runEmbeddedServer(server.Options{JetStream: true, StoreDir: storeDir})
+}
+
+func runEmbeddedServer(opts server.Options) (*EmbeddedServer, error) {
+	opts.Host = "127.0.0.1"
+	opts.Port = server.RANDOM_PORT
+	opts.NoLog = true
+	opts.NoSigs = true
+	s := &EmbeddedServer{opts: opts}
+	if err := s.start(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *EmbeddedServer) start() error {
+	opts := s.opts
+	srv, err := server.NewServer(&opts)
+	if err != nil {
+		return errors.Wrap(err, "unable to create embedded nats server")
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(10 * time.Second) {
+		srv.Shutdown()
+		return errors.New("embedded nats server did not start in time")
+	}
+	// keep the resolved port so that a restart listens on the same address
+	s.opts.Port = opts.Port
+	s.server = srv
+	return nil
+}
+
+func (s *EmbeddedServer) Url() string {
+	return fmt.Sprintf("nats://%s:%d", s.opts.Host, s.opts.Port)
+}
+
+func (s *EmbeddedServer) IsRunning() bool {
+	return s.server != nil
+}
+
+func (s *EmbeddedServer) Shutdown() {
+	if s.server != nil {
+		s.server.Shutdown()
+		s.server.WaitForShutdown()
+		s.server = nil
+	}
+}
+
+func (s *EmbeddedServer) Restart() error {
+	s.Shutdown()
+	return s.start()
+}
+
+// NewEmbeddedBroker starts an in-process nats server and exposes it as
+// BROKER_URL, it must be called before the App under test is created.
+func NewEmbeddedBroker(t *testing.T) *EmbeddedServer {
+	srv, err := RunEmbeddedServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Shutdown)
+	t.Setenv("BROKER_URL", srv.Url())
+	return srv
+}
+
+// NewEmbeddedJetStreamBroker is the JetStream flavour of NewEmbeddedBroker, the
+// App under test is switched to JetStream mode.
+func NewEmbeddedJetStreamBroker(t *testing.T) *EmbeddedServer {
+	srv, err := RunEmbeddedJetStreamServer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Shutdown)
+	t.Setenv("BROKER_URL", srv.Url())
+	t.Setenv("BROKER_JETSTREAM", "true")
+	return srv
+}
+
+// Pinger is implemented by broker.Client.
+type Pinger interface {
+	Ping() error
+}
+
+// Disconnect stops the server and waits until client notices the connection
+// loss.
+func (s *EmbeddedServer) Disconnect(t *testing.T, client Pinger) {
+	s.Shutdown()
+	waitForBroker(t, client, false)
+}
+
+// Reconnect restarts the server and waits until client is connected again.
+func (s *EmbeddedServer) Reconnect(t *testing.T, client Pinger) {
+	if err := s.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	waitForBroker(t, client, true)
+}
+
+func waitForBroker(t *testing.T, client Pinger, up bool) {
+	deadline := time.Now().Add(15 * time.Second)
+	for time.Now().Before(deadline) {
+		if (client.Ping() == nil) == up {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("broker connection did not change state in time (expected up: %v)", up)
+}
@@ -1,9 +1,9 @@
 package broker
 
 import (
+	"context"
 	"github.com/soffa-io/soffa-core-go/counters"
 	"github.com/soffa-io/soffa-core-go/errors"
-	"github.com/soffa-io/soffa-core-go/h"
 	"github.com/soffa-io/soffa-core-go/log"
 	"strings"
 )
@@ -11,13 +11,10 @@ import (
 var (
 	SendMessageCounter   = counters.NewCounter("x_sys_broker_send_message", "Will track messages sent", true)
 	MessageHandleCounter = counters.NewCounter("x_sys_broker_handle_message", "Will track messages received", true)
+	DisconnectCounter    = counters.NewCounter("x_sys_broker_disconnect", "Will track broker disconnections", true)
+	ReconnectCounter     = counters.NewCounter("x_sys_broker_reconnect", "Will track broker reconnections", true)
 )
 
-type Message struct {
-	Event string
-	Data  []byte
-}
-
 type Event struct {
 	Event string
 	Data  interface{}
@@ -27,31 +24,74 @@ type Handler = func(msg Message) interface{}
 
 type Client interface {
 	Start()
+	Shutdown(ctx context.Context) error
 	Ping() error
-	Publish(subject string, data interface{}) error
-	Request(subject string, data interface{}, dest interface{}) error
-	Subscribe(subject string, handler Handler)
+	Publish(subject string, data interface{}, opts ...PublishOption) error
+	PublishCtx(ctx context.Context, subject string, data interface{}, opts ...PublishOption) error
+	Request(subject string, data interface{}, dest interface{}, opts ...PublishOption) error
+	// RequestCtx waits for the reply until ctx is done or the request timeout
+	// expires. A handler failure is returned as a typed error (ErrFunctional...).
+	RequestCtx(ctx context.Context, subject string, data interface{}, dest interface{}, opts ...PublishOption) error
+	Subscribe(subject string, handler Handler, opts ...SubscribeOption)
+	// SubscribeQueue load-balances the messages of subject between the members
+	// of group (the service name when empty), each message is handled once.
+	SubscribeQueue(subject string, group string, handler Handler, opts ...SubscribeOption)
+	// UseCodec sets the codec of the messages sent to subjects (wildcards are
+	// supported), or the default codec of the client when no subject is given.
+	UseCodec(codec Codec, subjects ...string)
+	DeadLetters() DeadLetterQueue
+}
+
+// ContextPinger is implemented by the clients whose ping can be cancelled.
+type ContextPinger interface {
+	PingContext(ctx context.Context) error
+}
+
+type SubscribeOption = func(sub *subscription)
+
+type subscription struct {
+	subject string
+	group   string
+	handler Handler
+	retry   *RetryPolicy
+	inbox   InboxStore
+}
+
+func newSubscription(subject string, handler Handler, opts []SubscribeOption) *subscription {
+	sub := &subscription{subject: subject, handler: handler}
+	for _, opt := range opts {
+		opt(sub)
+	}
+	return sub
+}
+
+// WithRetry retries the handler according to policy, messages that exhaust
+// their retries are moved to a dead-letter subject.
+func WithRetry(policy RetryPolicy) SubscribeOption {
+	return func(sub *subscription) {
+		sub.retry = &policy
+	}
 }
 
 func NewClient(url string, name string) Client {
-	if strings.HasPrefix(url, "nats://") {
-		return newNatsMessageClient(url, name)
+	return NewClientWithConfig(url, name, DefaultConfig())
+}
+
+func NewClientWithConfig(url string, name string, config Config) Client {
+	if strings.HasPrefix(url, "nats://") || strings.HasPrefix(url, "tls://") || strings.HasPrefix(url, "jetstream://") {
+		return newNatsMessageClient(url, name, config)
 	} else if url == "mock" {
-		return NewMockClient(name)
+		return newMockClient(name, config)
 	}
 	log.Default.Fatal(errors.Errorf("unsupported broker url: %s (try nats:// or mock for tests)", url))
 	return nil
 }
 
-func (m Message) Decode(dest interface{}) error {
-	return h.DecodeBytes(m.Data, dest)
-}
-
 type Manager struct {
 	client Client
 }
 
-func (c Manager) Subscribe(event string, handler Handler) Manager {
-	c.client.Subscribe(event, handler)
+func (c Manager) Subscribe(event string, handler Handler, opts ...SubscribeOption) Manager {
+	c.client.Subscribe(event, handler, opts...)
 	return c
 }
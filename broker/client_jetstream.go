@@ -0,0 +1,229 @@
+package broker
+
+import (
+	"github.com/nats-io/nats.go"
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/soffa-io/soffa-core-go/h"
+	"github.com/soffa-io/soffa-core-go/log"
+	"strings"
+	"time"
+)
+
+const (
+	pullBatchSize = 10
+	pullMaxWait   = 2 * time.Second
+)
+
+func (n *NatsMessageClient) enableJetStream() error {
+	js, err := n.conn.JetStream()
+	if err != nil {
+		return err
+	}
+	n.js = js
+	if h.IsStrEmpty(n.config.Stream) {
+		n.config.Stream = strings.ToUpper(regexDurableName.ReplaceAllString(n.id, "_"))
+	}
+	if err := n.provisionStream(); err != nil {
+		return err
+	}
+	if err := n.provisionDeadLetterStream(); err != nil {
+		return err
+	}
+	n.loadStreams()
+	n.log.Infof("[nats] jetstream enabled (stream: %s, consumers: %s)", n.config.Stream, n.config.ConsumerMode)
+	return nil
+}
+
+// provisionStream creates the configured stream, or adds the configured subjects
+// to it when it already exists (other services may share the same stream).
+func (n *NatsMessageClient) provisionStream() error {
+	subjects := n.config.StreamSubjects
+	if len(subjects) == 0 {
+		return nil
+	}
+	name := n.config.Stream
+	info, err := n.js.StreamInfo(name)
+	if err == nats.ErrStreamNotFound {
+		_, err = n.js.AddStream(&nats.StreamConfig{
+			Name:      name,
+			Subjects:  subjects,
+			Storage:   nats.FileStorage,
+			Retention: nats.LimitsPolicy,
+		})
+		if err == nil {
+			n.log.Infof("[nats] stream %s created with subjects %v", name, subjects)
+		}
+		return errors.Wrapf(err, "unable to create stream %s", name)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "unable to read stream %s", name)
+	}
+	cfg := info.Config
+	updated := false
+	for _, subj := range subjects {
+		if !h.ContainsStr(cfg.Subjects, subj) {
+			cfg.Subjects = append(cfg.Subjects, subj)
+			updated = true
+		}
+	}
+	if updated {
+		if _, err = n.js.UpdateStream(&cfg); err != nil {
+			return errors.Wrapf(err, "unable to update stream %s", name)
+		}
+		n.log.Infof("[nats] stream %s updated with subjects %v", name, cfg.Subjects)
+	}
+	return nil
+}
+
+// provisionDeadLetterStream stores the dead letters of the service so that they
+// can be listed and replayed.
+func (n *NatsMessageClient) provisionDeadLetterStream() error {
+	name := "DLQ_" + strings.ToUpper(regexDurableName.ReplaceAllString(n.id, "_"))
+	subject := deadLetterSubject(n.id, ">")
+	_, err := n.js.StreamInfo(name)
+	if err == nats.ErrStreamNotFound {
+		_, err = n.js.AddStream(&nats.StreamConfig{
+			Name:      name,
+			Subjects:  []string{subject},
+			Storage:   nats.FileStorage,
+			Retention: nats.LimitsPolicy,
+		})
+	}
+	if err != nil {
+		return errors.Wrapf(err, "unable to provision dead-letter stream %s", name)
+	}
+	n.deadLetters = &jetStreamDeadLetters{client: n, stream: name}
+	return nil
+}
+
+// streamSubjects are the subject patterns captured by a stream.
+type streamSubjects struct {
+	name     string
+	patterns []string
+}
+
+// loadStreams reads the subjects of the streams once, streamFor matches the
+// subjects against them. It is called when JetStream is enabled, on Start and
+// on each subscription so that the streams created in the meantime are seen.
+func (n *NatsMessageClient) loadStreams() {
+	var streams []streamSubjects
+	for info := range n.js.StreamsInfo() {
+		streams = append(streams, streamSubjects{name: info.Config.Name, patterns: info.Config.Subjects})
+	}
+	n.streamsMu.Lock()
+	n.streams = streams
+	n.streamsMu.Unlock()
+}
+
+// streamFor returns the name of the stream that captures subj, or an empty
+// string when the subject is not backed by JetStream (request/reply subjects).
+func (n *NatsMessageClient) streamFor(subj string) string {
+	n.streamsMu.RLock()
+	defer n.streamsMu.RUnlock()
+	for _, stream := range n.streams {
+		for _, pattern := range stream.patterns {
+			if MatchSubject(pattern, subj) {
+				return stream.name
+			}
+		}
+	}
+	return ""
+}
+
+func (n *NatsMessageClient) subscribeJetStream(logger *log.Logger, stream string, sub *subscription) {
+	subj := sub.subject
+	durable := durableName(n.id, subj)
+	if sub.group != "" {
+		// members of a group share the consumer, whatever service they belong to
+		durable = durableName("group_"+sub.group, subj)
+	}
+	logger = logger.With("broker.stream", stream, "broker.consumer", durable)
+	pull := n.config.ConsumerMode == PullConsumer
+
+	if err := n.ensureConsumer(stream, durable, sub, pull); err != nil {
+		logger.Fatal(errors.Wrap(err, "unable to provision jetstream consumer"))
+	}
+
+	// messages are only consumed once the application is started so that they
+	// are not NAKed (and their delivery count wasted) during the bootstrap.
+	go func() {
+		<-n.ready
+		n.bindJetStream(logger, stream, durable, pull, sub)
+	}()
+}
+
+// bindJetStream binds to the consumer explicitly so that draining the connection
+// does not delete it, which would lose messages published while we are down.
+func (n *NatsMessageClient) bindJetStream(logger *log.Logger, stream string, durable string, pull bool, sub *subscription) {
+	subj := sub.subject
+	if pull {
+		ps, err := n.js.PullSubscribe(subj, durable, nats.Bind(stream, durable))
+		if err != nil {
+			logger.Fatal(errors.Wrap(err, "unable to subscribe to subject"))
+		}
+		logger.Info("jetstream pull subscription is active")
+		n.fetch(logger, ps, sub)
+		return
+	}
+	cb := func(m *nats.Msg) {
+		n.handle(logger, sub, m, true, 1)
+	}
+	var err error
+	if sub.group != "" {
+		_, err = n.js.QueueSubscribe(subj, sub.group, cb, nats.Bind(stream, durable), nats.ManualAck())
+	} else {
+		_, err = n.js.Subscribe(subj, cb, nats.Bind(stream, durable), nats.ManualAck())
+	}
+	if err != nil {
+		logger.Fatal(errors.Wrap(err, "unable to subscribe to subject"))
+	}
+	logger.Info("jetstream subscription is active")
+}
+
+func (n *NatsMessageClient) ensureConsumer(stream string, durable string, sub *subscription, pull bool) error {
+	info, err := n.js.ConsumerInfo(stream, durable)
+	if err == nil {
+		if info.Config.AckWait != n.config.AckWait || info.Config.MaxDeliver != n.config.MaxDeliver {
+			n.log.Warnf("[nats] consumer %s already exists with a different configuration, keeping it", durable)
+		}
+		return nil
+	}
+	if err != nats.ErrConsumerNotFound {
+		return err
+	}
+	cfg := &nats.ConsumerConfig{
+		Durable:       durable,
+		FilterSubject: sub.subject,
+		DeliverPolicy: nats.DeliverAllPolicy,
+		AckPolicy:     nats.AckExplicitPolicy,
+		AckWait:       n.config.AckWait,
+		MaxDeliver:    n.config.MaxDeliver,
+	}
+	if !pull {
+		cfg.DeliverSubject = nats.NewInbox()
+		cfg.DeliverGroup = sub.group
+	}
+	_, err = n.js.AddConsumer(stream, cfg)
+	return err
+}
+
+func (n *NatsMessageClient) fetch(logger *log.Logger, ps *nats.Subscription, sub *subscription) {
+	for ps.IsValid() {
+		msgs, err := ps.Fetch(pullBatchSize, nats.MaxWait(pullMaxWait))
+		if err != nil {
+			if err == nats.ErrTimeout {
+				continue
+			}
+			if !ps.IsValid() || n.conn.IsClosed() || n.conn.IsDraining() {
+				break
+			}
+			logger.Wrap(err, "[nats] unable to fetch messages")
+			time.Sleep(n.config.ReconnectWait)
+			continue
+		}
+		for _, m := range msgs {
+			n.handle(logger, sub, m, true, 1)
+		}
+	}
+	logger.Info("pull subscription stopped")
+}
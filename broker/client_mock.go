@@ -1,55 +1,152 @@
 package broker
 
 import (
+	"context"
 	"github.com/nats-io/nats.go"
 	"github.com/soffa-io/soffa-core-go/errors"
 	"github.com/soffa-io/soffa-core-go/h"
 	"github.com/soffa-io/soffa-core-go/log"
+	"sync"
+	"time"
 )
 
+type mockHandler = func(Message) (interface{}, error)
+
+type mockSubscription struct {
+	subject string
+	group   string
+	handler mockHandler
+}
+
+// FakeRpcClient is an in-memory broker that follows the NATS delivery rules:
+// wildcard subjects, every subscriber gets a copy of the message except queue
+// group members which share it (round-robin). Published messages are recorded.
 type FakeRpcClient struct {
 	Client
-	id       string
-	conn     *nats.Conn
-	subjects map[string]func(interface{}) (interface{}, error)
+	id          string
+	conn        *nats.Conn
+	mu          sync.Mutex
+	subs        []*mockSubscription
+	next        map[string]int
+	published   []Message
+	deadLetters *memoryDeadLetters
+	codecs      *codecRegistry
+	config      Config
 }
 
 func (n *FakeRpcClient) Start() {
 }
 
+func (n *FakeRpcClient) Shutdown(ctx context.Context) error {
+	return nil
+}
+
 func (n *FakeRpcClient) Ping() error {
 	return nil
 }
 
-func (n *FakeRpcClient) getFn(subj string) func(interface{}) (interface{}, error) {
-	for n, fn := range n.subjects {
-		if subj == n || n == "*" {
-			return fn
+func (n *FakeRpcClient) getFn(subj string) mockHandler {
+	handlers := n.handlers(subj)
+	if len(handlers) == 0 {
+		return nil
+	}
+	return handlers[0]
+}
+
+// handlers returns the handlers a message sent to subj is delivered to: every
+// plain subscription and one member of each queue group.
+func (n *FakeRpcClient) handlers(subj string) []mockHandler {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var out []mockHandler
+	groups := map[string][]mockHandler{}
+	var keys []string
+	for _, sub := range n.subs {
+		if !MatchSubject(sub.subject, subj) {
+			continue
+		}
+		if sub.group == "" {
+			out = append(out, sub.handler)
+			continue
 		}
+		key := sub.subject + " " + sub.group
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], sub.handler)
 	}
-	return nil
+	for _, key := range keys {
+		members := groups[key]
+		out = append(out, members[n.next[key]%len(members)])
+		n.next[key]++
+	}
+	return out
 }
 
-//goland:noinspection GoDeferInLoop
-func (n *FakeRpcClient) Publish(subj string, data interface{}) error {
-	return SendMessageCounter.Watch(func() error {
-		fn := n.getFn(subj)
-		if fn != nil {
-			defer func() {
-				_, _ = fn(data)
-			}()
-			return nil
+// Published returns the messages published to the subjects matching subject.
+func (n *FakeRpcClient) Published(subject string) []Message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var out []Message
+	for _, msg := range n.published {
+		if MatchSubject(subject, msg.Subject) {
+			out = append(out, msg)
 		}
-		return errors.Errorf("subject not found: %s", subj)
+	}
+	return out
+}
+
+func (n *FakeRpcClient) ResetPublished() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.published = nil
+}
+
+func (n *FakeRpcClient) Publish(subj string, data interface{}, opts ...PublishOption) error {
+	return n.PublishCtx(context.Background(), subj, data, opts...)
+}
+
+func (n *FakeRpcClient) PublishCtx(ctx context.Context, subj string, data interface{}, opts ...PublishOption) error {
+	err := SendMessageCounter.Watch(func() error {
+		msg, err := n.codecs.encode(n.id, subj, data, opts)
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return errors.Wrapf(err, "[fake.rpc] message not sent to %s", subj)
+		}
+		return n.publishMessage(msg)
 	})
+	recordPublish(subj, err)
+	return err
+}
+
+func (n *FakeRpcClient) publishMessage(msg Message) error {
+	n.mu.Lock()
+	n.published = append(n.published, msg)
+	n.mu.Unlock()
+	handlers := n.handlers(msg.Subject)
+	if len(handlers) == 0 {
+		return errors.Errorf("subject not found: %s", msg.Subject)
+	}
+	defer func() {
+		for _, fn := range handlers {
+			_, _ = fn(msg)
+		}
+	}()
+	return nil
 }
 
-func (n *FakeRpcClient) Request(subj string, data interface{}, dest interface{}) error {
-	return SendMessageCounter.Watch(func() error {
-		// bytes, err := prepareMessage(event, payload)
-		bytes, err := h.GetBytes(data)
+func (n *FakeRpcClient) Request(subj string, data interface{}, dest interface{}, opts ...PublishOption) error {
+	return n.RequestCtx(context.Background(), subj, data, dest, opts...)
+}
+
+func (n *FakeRpcClient) RequestCtx(ctx context.Context, subj string, data interface{}, dest interface{}, opts ...PublishOption) error {
+	start := time.Now()
+	err := SendMessageCounter.Watch(func() error {
+		req, err := n.codecs.encode(n.id, subj, data, opts)
 		if err != nil {
-			return errors.Wrapf(err, "[fake.rpc] bytes encoding failed -- %v", subj, err)
+			return err
 		}
 
 		fn := n.getFn(subj)
@@ -57,40 +154,116 @@ func (n *FakeRpcClient) Request(subj string, data interface{}, dest interface{})
 			return errors.Errorf("subject not found: %s", subj)
 		}
 
-		result, err := fn(bytes)
-		if err != nil {
-			return errors.Wrapf(err, "[fake.rpc] error sending message to %s -- %v", subj, err)
+		timeout := req.timeout
+		if timeout <= 0 {
+			timeout = n.config.RequestTimeout
+		}
+		ctx, cancel := withTimeout(ctx, timeout)
+		defer cancel()
+
+		type result struct {
+			response interface{}
+			err      error
+		}
+		done := make(chan result, 1)
+		go func() {
+			response, err := fn(req)
+			done <- result{response, err}
+		}()
+
+		var res result
+		select {
+		case res = <-done:
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "[fake.rpc] error sending message to %s", subj)
 		}
 
 		log.Default.Infof("[fake.rpc] message sent to to %s", subj)
 
-		if result == nil {
+		if res.err != nil {
+			return replyError(errorReply(res.err))
+		}
+		if res.response == nil {
 			return nil
 		}
-		return h.DecodeBytes(result, dest)
+		// the response goes through the codec as it would over the wire
+		reply, err := encodeReply(req, res.response)
+		if err != nil {
+			return err
+		}
+		return reply.Decode(dest)
 	})
+	recordRequest(subj, start, err)
+	return err
+}
+
+func (n *FakeRpcClient) Subscribe(subj string, handler Handler, opts ...SubscribeOption) {
+	n.subscribe(newSubscription(subj, handler, opts))
+}
+
+func (n *FakeRpcClient) SubscribeQueue(subj string, group string, handler Handler, opts ...SubscribeOption) {
+	sub := newSubscription(subj, handler, opts)
+	sub.group = h.AnyStr(group, n.id)
+	n.subscribe(sub)
 }
 
-func (n *FakeRpcClient) Subscribe(subj string, handler Handler) {
-	n.subjects[subj] = func(data interface{}) (interface{}, error) {
-		defer func() {
-			re := recover()
-			MessageHandleCounter.Recover(re, false)
-			if re != nil {
-				log.Default.Errorf("message handling failed [%s] -- %s", subj, re.(error).Error())
+func (n *FakeRpcClient) subscribe(sub *subscription) {
+	sub.idempotent(n.id)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.subs = append(n.subs, &mockSubscription{subject: sub.subject, group: sub.group, handler: n.handler(sub)})
+}
+
+func (n *FakeRpcClient) handler(sub *subscription) mockHandler {
+	subj := sub.subject
+	return func(bmsg Message) (interface{}, error) {
+		done := trackHandle(sub)
+		response, attempts, err := invoke(sub.handler, bmsg, sub.retry)
+		MessageHandleCounter.Record(err)
+		done(err)
+		if err != nil {
+			log.Default.Errorf("message handling failed [%s] -- %s", subj, err.Error())
+			if sub.retry != nil {
+				n.deadLetter(sub, bmsg, err, attempts)
 			}
-		}()
-		bytes, err := h.GetBytes(data)
-		errors.Raise(err)
-		bmsg := Message{Data: bytes}
-		response := handler(bmsg)
+			return nil, err
+		}
 		return h.Nil(response), nil
 	}
 }
 
+func (n *FakeRpcClient) UseCodec(codec Codec, subjects ...string) {
+	n.codecs.use(codec, subjects)
+}
+
+func (n *FakeRpcClient) DeadLetters() DeadLetterQueue {
+	return n.deadLetters
+}
+
+func (n *FakeRpcClient) deadLetter(sub *subscription, msg Message, cause error, attempts int) {
+	dl := DeadLetter{
+		Message:   msg,
+		Error:     cause.Error(),
+		Attempts:  attempts,
+		Timestamp: time.Now(),
+	}
+	n.deadLetters.add(dl)
+	subject := h.AnyStr(sub.retry.DeadLetter, deadLetterSubject(n.id, sub.subject))
+	_ = n.publishMessage(fromNats(dl.toNats(subject)))
+}
+
 func NewMockClient(name string) *FakeRpcClient {
+	return newMockClient(name, DefaultConfig())
+}
+
+func newMockClient(name string, config Config) *FakeRpcClient {
 	log.Default.Infof("[fakerpc] %s is now ready", name)
-	return &FakeRpcClient{
-		subjects: map[string]func(interface{}) (interface{}, error){},
+	client := &FakeRpcClient{
+		id:     name,
+		config: config,
+		codecs: newCodecRegistry(config.Codec),
+		next:   map[string]int{},
 	}
+	client.deadLetters = &memoryDeadLetters{publish: client.publishMessage}
+	return client
 }
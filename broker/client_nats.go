@@ -1,130 +1,420 @@
 package broker
 
 import (
+	"context"
 	"github.com/nats-io/nats.go"
 	"github.com/soffa-io/soffa-core-go/errors"
 	"github.com/soffa-io/soffa-core-go/h"
 	"github.com/soffa-io/soffa-core-go/log"
 	"github.com/soffa-io/soffa-core-go/sentry"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type NatsMessageClient struct {
 	Client
-	id   string
-	conn *nats.Conn
-	open bool
-	log  *log.Logger
+	id          string
+	conn        *nats.Conn
+	js          nats.JetStreamContext
+	config      Config
+	streams     []streamSubjects
+	streamsMu   sync.RWMutex
+	open        int32
+	retries     int32
+	deadLetters DeadLetterQueue
+	codecs      *codecRegistry
+	ready       chan struct{}
+	once        sync.Once
+	log         *log.Logger
 }
 
 func (n *NatsMessageClient) Ping() error {
+	return n.PingContext(context.Background())
+}
+
+func (n *NatsMessageClient) PingContext(ctx context.Context) error {
+	if !n.conn.IsConnected() {
+		return errors.Errorf("[nats] connection status is %s", n.conn.Status())
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+	}
+	if err := n.conn.FlushWithContext(ctx); err != nil {
+		return errors.Wrap(err, "[nats] server did not answer ping")
+	}
 	return nil
 }
 
 func (n *NatsMessageClient) Start() {
-	n.open = true
+	if n.js != nil {
+		n.loadStreams()
+	}
+	atomic.StoreInt32(&n.open, 1)
+	n.once.Do(func() {
+		close(n.ready)
+	})
+}
+
+// Shutdown waits for the scheduled redeliveries, drains every subscription so
+// that messages already received are handled, then closes the connection. It
+// gives up when ctx expires.
+func (n *NatsMessageClient) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&n.open, 0)
+	if n.conn.IsClosed() {
+		return nil
+	}
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for atomic.LoadInt32(&n.retries) > 0 {
+		select {
+		case <-ctx.Done():
+			n.conn.Close()
+			return errors.Wrap(ctx.Err(), "[nats] redeliveries did not complete in time")
+		case <-ticker.C:
+		}
+	}
+	if err := n.conn.Drain(); err != nil {
+		n.conn.Close()
+		return errors.Wrap(err, "[nats] drain failed")
+	}
+	for !n.conn.IsClosed() {
+		select {
+		case <-ctx.Done():
+			n.conn.Close()
+			return errors.Wrap(ctx.Err(), "[nats] drain timed out")
+		case <-ticker.C:
+		}
+	}
+	n.log.Info("connection drained and closed")
+	return nil
 }
 
-func (n *NatsMessageClient) Publish(subj string, data interface{}) error {
+func (n *NatsMessageClient) Publish(subj string, data interface{}, opts ...PublishOption) error {
+	return n.PublishCtx(context.Background(), subj, data, opts...)
+}
+
+func (n *NatsMessageClient) PublishCtx(ctx context.Context, subj string, data interface{}, opts ...PublishOption) error {
 	err := SendMessageCounter.Watch(func() error {
-		if bytes, err := h.GetBytes(data); err != nil {
+		msg, err := n.codecs.encode(n.id, subj, data, opts)
+		if err != nil {
 			return err
-		} else {
-			return n.conn.Publish(subj, bytes)
 		}
+		ctx, cancel := withTimeout(ctx, msg.timeout)
+		defer cancel()
+		return n.publishMsg(ctx, msg.toNats())
 	})
+	recordPublish(subj, err)
 	sentry.CaptureException(err)
 	return err
 }
 
-func (n *NatsMessageClient) Request(subj string, data interface{}, dest interface{}) error {
+func (n *NatsMessageClient) publishMessage(msg Message) error {
+	return n.publishMsg(context.Background(), msg.toNats())
+}
+
+func (n *NatsMessageClient) publishMsg(ctx context.Context, msg *nats.Msg) error {
+	if err := ctx.Err(); err != nil {
+		return errors.Wrapf(err, "[nats] message not sent to %s", msg.Subject)
+	}
+	if n.js != nil && !h.IsStrEmpty(n.streamFor(msg.Subject)) {
+		_, err := n.js.PublishMsg(msg, nats.Context(ctx))
+		return err
+	}
+	return n.conn.PublishMsg(msg)
+}
+
+func (n *NatsMessageClient) UseCodec(codec Codec, subjects ...string) {
+	n.codecs.use(codec, subjects)
+}
+
+func (n *NatsMessageClient) DeadLetters() DeadLetterQueue {
+	return n.deadLetters
+}
+
+func (n *NatsMessageClient) Request(subj string, data interface{}, dest interface{}, opts ...PublishOption) error {
+	return n.RequestCtx(context.Background(), subj, data, dest, opts...)
+}
+
+func (n *NatsMessageClient) RequestCtx(ctx context.Context, subj string, data interface{}, dest interface{}, opts ...PublishOption) error {
+	start := time.Now()
 	err := SendMessageCounter.Watch(func() error {
-		// bytes, err := prepareMessage(event, payload)
 		n.log.Infof("requesting data from channel :%s", subj)
-		bytes, err := h.GetBytes(data)
+		req, err := n.codecs.encode(n.id, subj, data, opts)
 		if err != nil {
 			n.log.Error(err)
-			return errors.Wrapf(err, "[nats] bytes encoding failed -- %v", subj, err)
+			return err
 		}
-		msg, err := n.conn.Request(subj, bytes, 10*time.Second)
+		timeout := req.timeout
+		if timeout <= 0 {
+			timeout = n.config.RequestTimeout
+		}
+		ctx, cancel := withTimeout(ctx, timeout)
+		defer cancel()
+		msg, err := n.conn.RequestMsgWithContext(ctx, req.toNats())
 		if err != nil {
 			n.log.Error(err)
-			return errors.Wrapf(err, "[nats] error sending message to %s -- %v", subj, err)
+			return errors.Wrapf(err, "[nats] error sending message to %s", subj)
 		}
 		n.log.Infof("response received from channel %s", subj)
-		return h.DecodeBytes(msg.Data, dest)
+		reply := fromNats(msg)
+		if err := replyError(reply); err != nil {
+			return err
+		}
+		return reply.Decode(dest)
 	})
+	recordRequest(subj, start, err)
 	sentry.CaptureException(err)
 	return err
 }
 
-func (n *NatsMessageClient) Subscribe(subj string, handler Handler) {
+func (n *NatsMessageClient) Subscribe(subj string, handler Handler, opts ...SubscribeOption) {
+	n.subscribe(newSubscription(subj, handler, opts))
+}
 
-	loggger := n.log.With("broker.subject", subj)
+func (n *NatsMessageClient) SubscribeQueue(subj string, group string, handler Handler, opts ...SubscribeOption) {
+	sub := newSubscription(subj, handler, opts)
+	sub.group = h.AnyStr(group, n.id)
+	n.subscribe(sub)
+}
 
-	_, err := n.conn.Subscribe(subj, func(m *nats.Msg) {
-		defer func() {
-			re := recover()
+func (n *NatsMessageClient) subscribe(sub *subscription) {
+	sub.idempotent(n.id)
+	subj := sub.subject
+	logger := n.log.With("broker.subject", subj)
+	if sub.group != "" {
+		logger = logger.With("broker.group", sub.group)
+	}
 
-			MessageHandleCounter.Recover(re, false)
+	if n.js != nil {
+		n.loadStreams()
+		if stream := n.streamFor(subj); !h.IsStrEmpty(stream) {
+			n.subscribeJetStream(logger, stream, sub)
+			return
+		}
+		logger.Warn("no jetstream stream matches subject, using a core nats subscription")
+	}
 
-			if re != nil {
-				sentry.CaptureException(re.(error))
-				loggger.Wrapf(re.(error), "[nats.%s] panic error received", subj)
-				if m.Reply == "" {
-					_ = m.Respond(nil)
-				}
-				_ = m.Nak()
-			}
-		}()
+	cb := func(m *nats.Msg) {
+		n.handle(logger, sub, m, false, 1)
+	}
+	var err error
+	if sub.group != "" {
+		_, err = n.conn.QueueSubscribe(subj, sub.group, cb)
+	} else {
+		_, err = n.conn.Subscribe(subj, cb)
+	}
+	if err != nil {
+		logger.Fatal("unable to subscribe to subject")
+	}
+	logger.Info("subscription is active")
+}
 
-		loggger.Info("new message received")
+// handle invokes the handler for a single message. JetStream messages are
+// acknowledged once handled. Without a retry policy, a failed JetStream message
+// is NAKed for redelivery, or terminated when the failure is functional
+// (redelivering would not help). With a retry policy, a failed message is
+// redelivered after the backoff (NAKed with a delay on JetStream, scheduled
+// again on core nats) then moved to the dead-letter subject.
+func (n *NatsMessageClient) handle(logger *log.Logger, sub *subscription, m *nats.Msg, jetstream bool, attempt int) {
+	logger.Info("new message received")
 
-		if !n.open {
-			loggger.Warn("sending NACK before application is not yet ready to receive messages")
+	// scheduled redeliveries were accepted before the shutdown, they still run
+	if attempt == 1 && atomic.LoadInt32(&n.open) == 0 {
+		logger.Warn("sending NACK before application is not yet ready to receive messages")
+		if jetstream {
 			_ = m.Nak()
-			if m.Reply == "" {
-				_ = m.Respond(nil)
-			}
-			return
+		} else if m.Reply != "" {
+			_ = m.Respond(nil)
 		}
+		return
+	}
 
-		if loggger.IsDebugEnabled() {
-			loggger.Debugf("%s", m.Data)
+	if logger.IsDebugEnabled() {
+		logger.Debugf("%s", m.Data)
+	}
+
+	if jetstream {
+		recordRedelivery(sub, m)
+		if meta, err := m.Metadata(); err == nil {
+			attempt = int(meta.NumDelivered)
 		}
+	}
+	done := trackHandle(sub)
+	bmsg := fromNats(m)
+	response, panicked, err := invokeOnce(sub.handler, bmsg)
+	MessageHandleCounter.Record(err)
+	done(err)
+	failure := "handler returned an error"
+	if panicked {
+		failure = "handler panicked"
+	}
 
-		bmsg := Message{Data: m.Data}
-		response := handler(bmsg)
+	if err != nil && !(jetstream && n.config.MaxDeliver > 0 && attempt >= n.config.MaxDeliver) {
+		if delay, retry := sub.retry.retryAfter(err, attempt); retry {
+			logger.Warnf("[nats.%s] %s on attempt %d, retrying in %s -- %v", m.Subject, failure, attempt, delay, err)
+			if jetstream {
+				_ = m.NakWithDelay(delay)
+			} else {
+				n.redeliver(logger, sub, m, attempt+1, delay)
+			}
+			return
+		}
+	}
 
-		if m.Reply == "" {
+	if err != nil {
+		sentry.CaptureException(err)
+		logger.Wrapf(err, "[nats.%s] %s", m.Subject, failure)
+		if sub.retry != nil {
+			n.deadLetter(logger, sub, bmsg, err, attempt)
+		}
+		if !jetstream {
+			if m.Reply != "" {
+				reply := errorReply(err)
+				reply.Subject = m.Reply
+				_ = m.RespondMsg(reply.toNats())
+			}
+		} else if sub.retry != nil {
 			_ = m.Ack()
+		} else if errors.IsFunctionalErr(err) {
+			_ = m.Term()
 		} else {
-			bytes, err := h.GetBytes(response)
-			if err != nil {
-				loggger.Wrap(err, "error encoding data to send back")
+			_ = m.Nak()
+		}
+		return
+	}
+
+	if jetstream {
+		if err := m.Ack(); err != nil {
+			logger.Wrap(err, "unable to ack message")
+		}
+	} else if m.Reply != "" {
+		reply, err := encodeReply(bmsg, response)
+		if err != nil {
+			logger.Wrap(err, "error encoding data to send back")
+			SendMessageCounter.Inc()
+		} else {
+			reply.Subject = m.Reply
+			if err = m.RespondMsg(reply.toNats()); err != nil {
+				logger.Wrapf(err, "error sending response to %s", m.Reply)
 				SendMessageCounter.Inc()
 			} else {
-				if err = m.Respond(bytes); err != nil {
-					loggger.Wrapf(err, "error sending response to %s", m.Reply)
-					SendMessageCounter.Inc()
-				} else {
-					loggger.Infof("data successfully sent back to %s", m.Reply)
-				}
+				logger.Infof("data successfully sent back to %s", m.Reply)
 			}
 		}
+	}
+}
+
+// redeliver handles a core nats message again after delay, the server does not
+// redeliver it.
+func (n *NatsMessageClient) redeliver(logger *log.Logger, sub *subscription, m *nats.Msg, attempt int, delay time.Duration) {
+	atomic.AddInt32(&n.retries, 1)
+	time.AfterFunc(delay, func() {
+		defer atomic.AddInt32(&n.retries, -1)
+		n.handle(logger, sub, m, false, attempt)
 	})
-	if err != nil {
-		loggger.Fatal("unable to subscribe to subject")
+}
+
+func (n *NatsMessageClient) deadLetter(logger *log.Logger, sub *subscription, m Message, cause error, attempts int) {
+	dl := DeadLetter{
+		Message:   m,
+		Error:     cause.Error(),
+		Attempts:  attempts,
+		Timestamp: time.Now(),
+	}
+	subject := h.AnyStr(sub.retry.DeadLetter, deadLetterSubject(n.id, m.Subject))
+	if err := n.publishMsg(context.Background(), dl.toNats(subject)); err != nil {
+		logger.Wrapf(err, "unable to publish dead letter to %s", subject)
+	} else {
+		logger.Warnf("message moved to %s after %d attempt(s)", subject, attempts)
+	}
+	if memory, ok := n.deadLetters.(*memoryDeadLetters); ok {
+		memory.add(dl)
 	}
-	loggger.Info("subscription is active")
 }
 
-func newNatsMessageClient(url string, name string) Client {
+func newNatsMessageClient(url string, name string, config Config) Client {
+	if strings.HasPrefix(url, "jetstream://") {
+		url = "nats://" + strings.TrimPrefix(url, "jetstream://")
+		config.JetStream = true
+	}
+	client := &NatsMessageClient{
+		id:     name,
+		config: config,
+		codecs: newCodecRegistry(config.Codec),
+		ready:  make(chan struct{}),
+		log:    log.Default.With("broker", "nats", "broker.name", name),
+	}
+	opts, err := client.options(config)
+	if err != nil {
+		log.Default.Fatal(errors.Wrap(err, "invalid nats configuration"))
+	}
 	log.Default.Infof("connecting to nats instance %s", url)
-	nc, err := nats.Connect(url)
+	nc, err := nats.Connect(url, opts...)
 	if err != nil {
 		log.Default.Fatal(errors.Wrapf(err, "error connecting to nats server: %s", url))
 	}
 	log.Default.Infof("application is now connected to nats server %s", url)
-	return &NatsMessageClient{conn: nc, id: name, log: log.Default.With("broker", "nats", "broker.name", name)}
+	client.conn = nc
+	client.deadLetters = &memoryDeadLetters{publish: client.publishMessage}
+	if config.JetStream {
+		if err := client.enableJetStream(); err != nil {
+			log.Default.Fatal(errors.Wrap(err, "unable to enable jetstream"))
+		}
+	}
+	return client
+}
+
+func (n *NatsMessageClient) options(config Config) ([]nats.Option, error) {
+	opts := []nats.Option{
+		nats.Name(n.id),
+		nats.ReconnectWait(config.ReconnectWait),
+		nats.MaxReconnects(config.MaxReconnects),
+		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
+			DisconnectCounter.Inc()
+			if err != nil {
+				n.log.Wrap(err, "[nats] disconnected from server")
+			} else {
+				n.log.Warn("[nats] disconnected from server")
+			}
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			ReconnectCounter.Inc()
+			n.log.Infof("[nats] reconnected to %s", nc.ConnectedUrl())
+		}),
+		nats.ClosedHandler(func(nc *nats.Conn) {
+			if err := nc.LastError(); err != nil {
+				n.log.Wrap(err, "[nats] connection closed")
+			} else {
+				n.log.Info("[nats] connection closed")
+			}
+		}),
+		nats.ErrorHandler(func(nc *nats.Conn, sub *nats.Subscription, err error) {
+			if sub != nil {
+				n.log.Wrapf(err, "[nats] async error on subject %s", sub.Subject)
+			} else {
+				n.log.Wrap(err, "[nats] async error")
+			}
+		}),
+	}
+	if !h.IsStrEmpty(config.Credentials) {
+		opts = append(opts, nats.UserCredentials(config.Credentials))
+	}
+	if !h.IsStrEmpty(config.NKeySeed) {
+		opt, err := nats.NkeyOptionFromSeed(config.NKeySeed)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to load nkey seed %s", config.NKeySeed)
+		}
+		opts = append(opts, opt)
+	}
+	if !h.IsStrEmpty(config.TLSCert) || !h.IsStrEmpty(config.TLSKey) {
+		opts = append(opts, nats.ClientCert(config.TLSCert, config.TLSKey))
+	}
+	if !h.IsStrEmpty(config.TLSCA) {
+		opts = append(opts, nats.RootCAs(config.TLSCA))
+	}
+	return opts, nil
 }
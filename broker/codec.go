@@ -0,0 +1,226 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/soffa-io/soffa-core-go/h"
+	"github.com/soffa-io/soffa-core-go/log"
+	"google.golang.org/protobuf/proto"
+)
+
+const HeaderContentType = "Content-Type"
+
+// Codec encodes the payloads exchanged through the broker. The content type
+// travels with each message so that consumers pick the matching codec.
+type Codec interface {
+	Name() string
+	ContentType() string
+	Encode(data interface{}) ([]byte, error)
+	Decode(data []byte, dest interface{}) error
+}
+
+var (
+	JSON     Codec = jsonCodec{}
+	Gob      Codec = gobCodec{}
+	Protobuf Codec = protobufCodec{}
+
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{}
+)
+
+func init() {
+	RegisterCodec(JSON)
+	RegisterCodec(Gob)
+	RegisterCodec(Protobuf)
+}
+
+// RegisterCodec makes a codec available by name and content type.
+func RegisterCodec(codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[strings.ToLower(codec.Name())] = codec
+	codecs[strings.ToLower(codec.ContentType())] = codec
+}
+
+// LookupCodec finds a registered codec by name or content type. Messages without
+// a content type were sent before codecs existed and are gob encoded.
+func LookupCodec(nameOrContentType string) (Codec, bool) {
+	if h.IsStrEmpty(nameOrContentType) {
+		return Gob, true
+	}
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, ok := codecs[strings.ToLower(nameOrContentType)]
+	return codec, ok
+}
+
+func WithCodec(codec Codec) PublishOption {
+	return func(msg *Message) {
+		msg.ContentType = codec.ContentType()
+	}
+}
+
+func (m Message) Decode(dest interface{}) error {
+	if h.IsNil(dest) {
+		return errors.New("unable to decode bytes into nul reference")
+	}
+	if len(m.Data) == 0 {
+		return nil
+	}
+	if raw, ok := dest.(*[]byte); ok {
+		*raw = m.Data
+		return nil
+	}
+	codec, ok := LookupCodec(m.ContentType)
+	if !ok {
+		return errors.Errorf("no codec registered for content type %s", m.ContentType)
+	}
+	return codec.Decode(m.Data, dest)
+}
+
+type subjectCodec struct {
+	pattern string
+	codec   Codec
+}
+
+// codecRegistry resolves the codec of the messages sent by a client, the last
+// codec registered for a matching subject wins over the client default.
+type codecRegistry struct {
+	mu        sync.RWMutex
+	def       Codec
+	bySubject []subjectCodec
+}
+
+func newCodecRegistry(name string) *codecRegistry {
+	codec, ok := LookupCodec(h.AnyStr(name, JSON.Name()))
+	if !ok {
+		log.Default.Fatal(errors.Errorf("unknown broker codec: %s", name))
+	}
+	return &codecRegistry{def: codec}
+}
+
+func (r *codecRegistry) use(codec Codec, subjects []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(subjects) == 0 {
+		r.def = codec
+		return
+	}
+	for _, subject := range subjects {
+		r.bySubject = append(r.bySubject, subjectCodec{pattern: subject, codec: codec})
+	}
+}
+
+func (r *codecRegistry) forSubject(subject string) Codec {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for i := len(r.bySubject) - 1; i >= 0; i-- {
+		if MatchSubject(r.bySubject[i].pattern, subject) {
+			return r.bySubject[i].codec
+		}
+	}
+	return r.def
+}
+
+// encode builds the envelope of data sent to subject. Raw bytes are sent as is,
+// they are expected to be already encoded with the selected codec.
+func (r *codecRegistry) encode(source string, subject string, data interface{}, opts []PublishOption) (Message, error) {
+	msg := newMessage(source, subject, nil, opts)
+	codec := r.forSubject(subject)
+	if !h.IsStrEmpty(msg.ContentType) {
+		c, ok := LookupCodec(msg.ContentType)
+		if !ok {
+			return msg, errors.Errorf("no codec registered for content type %s", msg.ContentType)
+		}
+		codec = c
+	}
+	msg.ContentType = codec.ContentType()
+	if raw, ok := data.([]byte); ok {
+		msg.Data = raw
+		return msg, nil
+	}
+	if h.IsNil(data) {
+		return msg, nil
+	}
+	encoded, err := codec.Encode(data)
+	if err != nil {
+		return msg, errors.Wrapf(err, "[%s] encoding failed -- %s", codec.Name(), subject)
+	}
+	msg.Data = encoded
+	return msg, nil
+}
+
+// encodeReply encodes the response to msg with the codec of the request.
+func encodeReply(msg Message, response interface{}) (Message, error) {
+	reply := Message{ContentType: msg.ContentType}
+	if raw, ok := response.([]byte); ok {
+		reply.Data = raw
+		return reply, nil
+	}
+	if h.IsNil(response) {
+		return reply, nil
+	}
+	codec, ok := LookupCodec(msg.ContentType)
+	if !ok {
+		return reply, errors.Errorf("no codec registered for content type %s", msg.ContentType)
+	}
+	encoded, err := codec.Encode(response)
+	reply.Data = encoded
+	return reply, err
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string        { return "json" }
+func (jsonCodec) ContentType() string { return "application/json" }
+
+func (jsonCodec) Encode(data interface{}) ([]byte, error) {
+	return json.Marshal(data)
+}
+
+func (jsonCodec) Decode(data []byte, dest interface{}) error {
+	return json.Unmarshal(data, dest)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string        { return "gob" }
+func (gobCodec) ContentType() string { return "application/x-gob" }
+
+func (gobCodec) Encode(data interface{}) ([]byte, error) {
+	b := bytes.Buffer{}
+	if err := gob.NewEncoder(&b).Encode(data); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (gobCodec) Decode(data []byte, dest interface{}) error {
+	return gob.NewDecoder(bytes.NewBuffer(data)).Decode(dest)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string        { return "protobuf" }
+func (protobufCodec) ContentType() string { return "application/x-protobuf" }
+
+func (protobufCodec) Encode(data interface{}) ([]byte, error) {
+	msg, ok := data.(proto.Message)
+	if !ok {
+		return nil, errors.Errorf("%T is not a protobuf message", data)
+	}
+	return proto.Marshal(msg)
+}
+
+func (protobufCodec) Decode(data []byte, dest interface{}) error {
+	msg, ok := dest.(proto.Message)
+	if !ok {
+		return errors.Errorf("%T is not a protobuf message", dest)
+	}
+	return proto.Unmarshal(data, msg)
+}
@@ -0,0 +1,79 @@
+package broker
+
+import (
+	"github.com/soffa-io/soffa-core-go/conf"
+	"github.com/soffa-io/soffa-core-go/h"
+	"strings"
+	"time"
+)
+
+const (
+	PushConsumer = "push"
+	PullConsumer = "pull"
+)
+
+type Config struct {
+	ReconnectWait  time.Duration
+	MaxReconnects  int
+	Credentials    string
+	NKeySeed       string
+	TLSCert        string
+	TLSKey         string
+	TLSCA          string
+	JetStream      bool
+	Stream         string
+	StreamSubjects []string
+	ConsumerMode   string
+	AckWait        time.Duration
+	MaxDeliver     int
+	Codec          string
+	RequestTimeout time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		ReconnectWait:  2 * time.Second,
+		MaxReconnects:  60,
+		ConsumerMode:   PushConsumer,
+		AckWait:        30 * time.Second,
+		MaxDeliver:     5,
+		Codec:          "json",
+		RequestTimeout: 10 * time.Second,
+	}
+}
+
+// LoadConfig reads the broker settings from the config manager (vault or env),
+// missing entries keep their default value. A negative max reconnects value
+// means the client never stops trying to reconnect. Stream subjects are a comma
+// separated list.
+func LoadConfig(cfg *conf.Manager) Config {
+	def := DefaultConfig()
+	return Config{
+		ReconnectWait: cfg.GetDuration(def.ReconnectWait, "broker.reconnect.wait", "BROKER_RECONNECT_WAIT"),
+		MaxReconnects: cfg.GetInt(def.MaxReconnects, "broker.reconnect.max", "BROKER_MAX_RECONNECTS"),
+		Credentials:   cfg.Get("broker.credentials", "BROKER_CREDENTIALS"),
+		NKeySeed:      cfg.Get("broker.nkey", "BROKER_NKEY"),
+		TLSCert:       cfg.Get("broker.tls.cert", "BROKER_TLS_CERT"),
+		TLSKey:        cfg.Get("broker.tls.key", "BROKER_TLS_KEY"),
+		TLSCA:         cfg.Get("broker.tls.ca", "BROKER_TLS_CA"),
+
+		JetStream:      cfg.GetBool(false, "broker.jetstream", "BROKER_JETSTREAM"),
+		Stream:         cfg.Get("broker.stream", "BROKER_STREAM"),
+		StreamSubjects: splitList(cfg.Get("broker.stream.subjects", "BROKER_STREAM_SUBJECTS")),
+		ConsumerMode:   h.AnyStr(strings.ToLower(cfg.Get("broker.consumer.mode", "BROKER_CONSUMER_MODE")), def.ConsumerMode),
+		AckWait:        cfg.GetDuration(def.AckWait, "broker.ack.wait", "BROKER_ACK_WAIT"),
+		MaxDeliver:     cfg.GetInt(def.MaxDeliver, "broker.max.deliver", "BROKER_MAX_DELIVER"),
+		Codec:          h.AnyStr(cfg.Get("broker.codec", "BROKER_CODEC"), def.Codec),
+		RequestTimeout: cfg.GetDuration(def.RequestTimeout, "broker.request.timeout", "BROKER_REQUEST_TIMEOUT"),
+	}
+}
+
+func splitList(value string) []string {
+	var out []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); !h.IsStrEmpty(item) {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,161 @@
+package broker
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/soffa-io/soffa-core-go/h"
+)
+
+const (
+	HeaderDeadLetterSubject  = "X-Dead-Letter-Subject"
+	HeaderDeadLetterError    = "X-Dead-Letter-Error"
+	HeaderDeadLetterAttempts = "X-Dead-Letter-Attempts"
+	HeaderDeadLetterTime     = "X-Dead-Letter-Time"
+
+	maxMemoryDeadLetters = 1000
+)
+
+// DeadLetter is a message whose handler kept failing after all its retries.
+type DeadLetter struct {
+	ID        string
+	Message   Message
+	Error     string
+	Attempts  int
+	Timestamp time.Time
+}
+
+type DeadLetterQueue interface {
+	// List returns the dead letters of the subjects matching subject (wildcards
+	// are supported), an empty subject lists everything.
+	List(subject string) ([]DeadLetter, error)
+	// Replay publishes the dead letter again on its original subject and
+	// removes it from the queue.
+	Replay(id string) error
+}
+
+func deadLetterSubject(service string, subject string) string {
+	return "dlq." + regexDurableName.ReplaceAllString(service, "_") + "." + subject
+}
+
+// toNats returns the original message, with its envelope, sent to subject.
+func (dl DeadLetter) toNats(subject string) *nats.Msg {
+	msg := dl.Message.toNats()
+	msg.Subject = subject
+	msg.Header.Set(HeaderDeadLetterSubject, dl.Message.Subject)
+	msg.Header.Set(HeaderDeadLetterError, dl.Error)
+	msg.Header.Set(HeaderDeadLetterAttempts, strconv.Itoa(dl.Attempts))
+	msg.Header.Set(HeaderDeadLetterTime, dl.Timestamp.UTC().Format(time.RFC3339Nano))
+	return msg
+}
+
+func parseDeadLetter(id string, header nats.Header, data []byte) DeadLetter {
+	attempts, _ := strconv.Atoi(header.Get(HeaderDeadLetterAttempts))
+	ts, _ := time.Parse(time.RFC3339Nano, header.Get(HeaderDeadLetterTime))
+	dl := DeadLetter{
+		ID:        id,
+		Message:   fromNats(&nats.Msg{Subject: header.Get(HeaderDeadLetterSubject), Header: header, Data: data}),
+		Error:     header.Get(HeaderDeadLetterError),
+		Attempts:  attempts,
+		Timestamp: ts,
+	}
+	for _, k := range []string{HeaderDeadLetterSubject, HeaderDeadLetterError, HeaderDeadLetterAttempts, HeaderDeadLetterTime} {
+		delete(dl.Message.Headers, k)
+	}
+	if len(dl.Message.Headers) == 0 {
+		dl.Message.Headers = nil
+	}
+	return dl
+}
+
+// memoryDeadLetters keeps the most recent dead letters of the process, it is
+// used by the mock client and by core nats where nothing is persisted.
+type memoryDeadLetters struct {
+	mu      sync.Mutex
+	items   []DeadLetter
+	publish func(msg Message) error
+}
+
+func (q *memoryDeadLetters) add(dl DeadLetter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	dl.ID = h.NewUniqueId()
+	q.items = append(q.items, dl)
+	if len(q.items) > maxMemoryDeadLetters {
+		q.items = q.items[len(q.items)-maxMemoryDeadLetters:]
+	}
+}
+
+func (q *memoryDeadLetters) List(subject string) ([]DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var out []DeadLetter
+	for _, dl := range q.items {
+		if h.IsStrEmpty(subject) || MatchSubject(subject, dl.Message.Subject) {
+			out = append(out, dl)
+		}
+	}
+	return out, nil
+}
+
+func (q *memoryDeadLetters) Replay(id string) error {
+	q.mu.Lock()
+	var found *DeadLetter
+	for i, dl := range q.items {
+		if dl.ID == id {
+			found = &dl
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			break
+		}
+	}
+	q.mu.Unlock()
+	if found == nil {
+		return errors.NewFunctionalError(errors.ErrNotFoundCode, "dead letter not found: "+id)
+	}
+	return q.publish(found.Message)
+}
+
+// jetStreamDeadLetters reads the dead letters back from the service DLQ stream.
+type jetStreamDeadLetters struct {
+	client *NatsMessageClient
+	stream string
+}
+
+func (q *jetStreamDeadLetters) List(subject string) ([]DeadLetter, error) {
+	info, err := q.client.js.StreamInfo(q.stream)
+	if err != nil {
+		return nil, err
+	}
+	var out []DeadLetter
+	for seq := info.State.FirstSeq; seq > 0 && seq <= info.State.LastSeq; seq++ {
+		raw, err := q.client.js.GetMsg(q.stream, seq)
+		if err != nil {
+			// deleted (replayed) messages leave holes in the sequence
+			continue
+		}
+		dl := parseDeadLetter(strconv.FormatUint(seq, 10), raw.Header, raw.Data)
+		if h.IsStrEmpty(subject) || MatchSubject(subject, dl.Message.Subject) {
+			out = append(out, dl)
+		}
+	}
+	return out, nil
+}
+
+func (q *jetStreamDeadLetters) Replay(id string) error {
+	seq, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return errors.NewFunctionalError(errors.ErrNotFoundCode, "invalid dead letter id: "+id)
+	}
+	raw, err := q.client.js.GetMsg(q.stream, seq)
+	if err != nil {
+		return errors.Wrapf(err, "dead letter %s not found", id)
+	}
+	dl := parseDeadLetter(id, raw.Header, raw.Data)
+	if err := q.client.publishMessage(dl.Message); err != nil {
+		return err
+	}
+	return q.client.js.DeleteMsg(q.stream, seq)
+}
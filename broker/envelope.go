@@ -0,0 +1,202 @@
+package broker
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	sctx "github.com/soffa-io/soffa-core-go/context"
+	"github.com/soffa-io/soffa-core-go/h"
+)
+
+const (
+	HeaderMessageId     = "X-Message-Id"
+	HeaderEvent         = "X-Event"
+	HeaderTimestamp     = "X-Timestamp"
+	HeaderSource        = "X-Source"
+	HeaderTenant        = "X-Tenant-Id"
+	HeaderCorrelationId = "X-Correlation-Id"
+	HeaderCausationId   = "X-Causation-Id"
+)
+
+var envelopeHeaders = []string{
+	HeaderMessageId, HeaderEvent, HeaderTimestamp, HeaderSource, HeaderTenant, HeaderCorrelationId, HeaderCausationId, HeaderContentType,
+}
+
+// Message is the envelope of the data exchanged through the broker. The
+// envelope fields travel as NATS headers, Headers holds any other header.
+type Message struct {
+	ID            string
+	Subject       string
+	Event         string
+	Timestamp     time.Time
+	Source        string
+	TenantId      string
+	CorrelationId string
+	CausationId   string
+	ContentType   string
+	Headers       map[string]string
+	Data          []byte
+	timeout       time.Duration
+	ctx           context.Context
+}
+
+type PublishOption = func(msg *Message)
+
+// WithMessageId overrides the generated message id, consumers use it to detect
+// duplicates (outbox relay retries, redeliveries).
+func WithMessageId(id string) PublishOption {
+	return func(msg *Message) {
+		msg.ID = id
+	}
+}
+
+func WithEvent(event string) PublishOption {
+	return func(msg *Message) {
+		msg.Event = event
+	}
+}
+
+func WithTenant(tenantId string) PublishOption {
+	return func(msg *Message) {
+		msg.TenantId = tenantId
+	}
+}
+
+func WithCorrelationId(id string) PublishOption {
+	return func(msg *Message) {
+		msg.CorrelationId = id
+	}
+}
+
+func WithCausationId(id string) PublishOption {
+	return func(msg *Message) {
+		msg.CausationId = id
+	}
+}
+
+func WithHeader(key string, value string) PublishOption {
+	return func(msg *Message) {
+		if msg.Headers == nil {
+			msg.Headers = map[string]string{}
+		}
+		msg.Headers[key] = value
+	}
+}
+
+// CausedBy marks the message as a consequence of parent: it joins the parent
+// correlation and keeps its tenant.
+func CausedBy(parent Message) PublishOption {
+	return func(msg *Message) {
+		msg.CorrelationId = h.AnyStr(parent.CorrelationId, parent.ID)
+		msg.CausationId = parent.ID
+		if h.IsStrEmpty(msg.TenantId) {
+			msg.TenantId = parent.TenantId
+		}
+	}
+}
+
+// Context carries the values attached while the message is handled, like the
+// transaction opened by an inbox store.
+func (m Message) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
+func (m Message) WithContext(ctx context.Context) Message {
+	m.ctx = ctx
+	return m
+}
+
+// withTenant attaches the tenant of the message to its context, the handlers
+// get their db.Link with db.LinkFrom(msg.Context(), link).
+func (m Message) withTenant() Message {
+	if h.IsStrEmpty(m.TenantId) {
+		return m
+	}
+	return m.WithContext(sctx.WithTenant(m.Context(), m.TenantId))
+}
+
+func (m Message) Header(key string) string {
+	return m.Headers[key]
+}
+
+func newMessage(source string, subject string, data []byte, opts []PublishOption) Message {
+	msg := Message{
+		Subject:   subject,
+		Timestamp: time.Now().UTC(),
+		Source:    source,
+		Data:      data,
+	}
+	for _, opt := range opts {
+		opt(&msg)
+	}
+	if h.IsStrEmpty(msg.ID) {
+		msg.ID = h.NewUniqueId()
+	}
+	if h.IsStrEmpty(msg.CorrelationId) {
+		msg.CorrelationId = msg.ID
+	}
+	return msg
+}
+
+func (m Message) toNats() *nats.Msg {
+	header := nats.Header{}
+	for k, v := range m.Headers {
+		header.Set(k, v)
+	}
+	setHeader(header, HeaderMessageId, m.ID)
+	setHeader(header, HeaderEvent, m.Event)
+	if !m.Timestamp.IsZero() {
+		header.Set(HeaderTimestamp, m.Timestamp.Format(time.RFC3339Nano))
+	}
+	setHeader(header, HeaderSource, m.Source)
+	setHeader(header, HeaderTenant, m.TenantId)
+	setHeader(header, HeaderCorrelationId, m.CorrelationId)
+	setHeader(header, HeaderCausationId, m.CausationId)
+	setHeader(header, HeaderContentType, m.ContentType)
+	return &nats.Msg{Subject: m.Subject, Data: m.Data, Header: header}
+}
+
+func fromNats(m *nats.Msg) Message {
+	msg := Message{Subject: m.Subject, Data: m.Data}
+	if m.Header == nil {
+		return msg
+	}
+	msg.ID = m.Header.Get(HeaderMessageId)
+	msg.Event = m.Header.Get(HeaderEvent)
+	msg.Timestamp, _ = time.Parse(time.RFC3339Nano, m.Header.Get(HeaderTimestamp))
+	msg.Source = m.Header.Get(HeaderSource)
+	msg.TenantId = m.Header.Get(HeaderTenant)
+	msg.CorrelationId = m.Header.Get(HeaderCorrelationId)
+	msg.CausationId = m.Header.Get(HeaderCausationId)
+	msg.ContentType = m.Header.Get(HeaderContentType)
+	for k := range m.Header {
+		if isEnvelopeHeader(k) {
+			continue
+		}
+		if msg.Headers == nil {
+			msg.Headers = map[string]string{}
+		}
+		msg.Headers[k] = m.Header.Get(k)
+	}
+	return msg
+}
+
+func setHeader(header nats.Header, key string, value string) {
+	if !h.IsStrEmpty(value) {
+		header.Set(key, value)
+	}
+}
+
+func isEnvelopeHeader(key string) bool {
+	for _, k := range envelopeHeaders {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package broker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/soffa-io/soffa-core-go/counters"
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/soffa-io/soffa-core-go/h"
+	"github.com/soffa-io/soffa-core-go/log"
+)
+
+var DuplicateMessageCounter = counters.NewCounter("x_sys_broker_duplicate_message", "Will track duplicate messages skipped", true)
+
+// InboxStore records the messages processed by a consumer. Process runs fn
+// unless messageId was already processed, fn and the record must commit
+// together (db.Inbox runs both in the same transaction).
+type InboxStore interface {
+	Process(ctx context.Context, consumer string, messageId string, tenant string, fn func(ctx context.Context) error) (duplicate bool, err error)
+}
+
+// WithInbox makes the handler idempotent: a message whose id was already
+// processed by this consumer (service or queue group + subject) is skipped.
+func WithInbox(store InboxStore) SubscribeOption {
+	return func(sub *subscription) {
+		sub.inbox = store
+	}
+}
+
+// idempotent wraps the handler of sub with its inbox, if any. Messages without
+// an id (sent by older clients) are always handled.
+func (sub *subscription) idempotent(service string) {
+	if sub.inbox == nil {
+		return
+	}
+	consumer := h.AnyStr(sub.group, service) + ":" + sub.subject
+	handler := sub.handler
+	store := sub.inbox
+	sub.handler = func(msg Message) interface{} {
+		if h.IsStrEmpty(msg.ID) {
+			return handler(msg)
+		}
+		var response interface{}
+		duplicate, err := store.Process(msg.Context(), consumer, msg.ID, msg.TenantId, func(ctx context.Context) error {
+			response = handler(msg.WithContext(ctx))
+			return nil
+		})
+		errors.Raise(err)
+		if duplicate {
+			DuplicateMessageCounter.Inc()
+			log.Default.Infof("[%s] message %s already processed, skipping", consumer, msg.ID)
+			return nil
+		}
+		return response
+	}
+}
+
+// MemoryInbox is an in-process InboxStore meant for tests, entries expire after
+// ttl.
+type MemoryInbox struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	processed map[string]time.Time
+}
+
+func NewMemoryInbox(ttl time.Duration) *MemoryInbox {
+	return &MemoryInbox{ttl: ttl, processed: map[string]time.Time{}}
+}
+
+func (i *MemoryInbox) Process(ctx context.Context, consumer string, messageId string, tenant string, fn func(ctx context.Context) error) (bool, error) {
+	key := tenant + "/" + consumer + "/" + messageId
+	i.mu.Lock()
+	i.purge()
+	_, found := i.processed[key]
+	i.mu.Unlock()
+	if found {
+		return true, nil
+	}
+	if err := fn(ctx); err != nil {
+		return false, err
+	}
+	i.mu.Lock()
+	i.processed[key] = time.Now()
+	i.mu.Unlock()
+	return false, nil
+}
+
+func (i *MemoryInbox) purge() {
+	if i.ttl <= 0 {
+		return
+	}
+	limit := time.Now().Add(-i.ttl)
+	for key, at := range i.processed {
+		if at.Before(limit) {
+			delete(i.processed, key)
+		}
+	}
+}
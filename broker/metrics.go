@@ -0,0 +1,74 @@
+package broker
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/soffa-io/soffa-core-go/counters"
+	"github.com/soffa-io/soffa-core-go/errors"
+)
+
+// Labelled broker metrics. Handling metrics are labelled with the subscribed
+// subject (which may be a wildcard) to keep the cardinality bounded, publishing
+// metrics with the subject the message is sent to.
+var (
+	MessagesCounter   = counters.NewCounterVec("x_sys_broker_messages", "Will track broker messages by subject, operation and outcome", "subject", "operation", "outcome")
+	HandleLatency     = counters.NewHistogram("x_sys_broker_handle_seconds", "Will track message handling latency", nil, "subject", "outcome")
+	RequestLatency    = counters.NewHistogram("x_sys_broker_request_seconds", "Will track request/reply latency", nil, "subject", "outcome")
+	InFlightGauge     = counters.NewGauge("x_sys_broker_in_flight", "Will track messages being handled", "subject")
+	RedeliveryCounter = counters.NewCounterVec("x_sys_broker_redelivered", "Will track messages redelivered by JetStream", "subject")
+)
+
+const (
+	OpPublish = "publish"
+	OpRequest = "request"
+	OpHandle  = "handle"
+
+	OutcomeSuccess    = "success"
+	OutcomeFunctional = "functional_error"
+	OutcomeTimeout    = "timeout"
+	OutcomeError      = "error"
+)
+
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout):
+		return OutcomeTimeout
+	case errors.IsFunctionalErr(err):
+		return OutcomeFunctional
+	default:
+		return OutcomeError
+	}
+}
+
+func recordPublish(subject string, err error) {
+	MessagesCounter.Inc(subject, OpPublish, outcome(err))
+}
+
+func recordRequest(subject string, start time.Time, err error) {
+	result := outcome(err)
+	MessagesCounter.Inc(subject, OpRequest, result)
+	RequestLatency.Since(start, subject, result)
+}
+
+// trackHandle marks a message of sub as in-flight, the returned function records
+// the outcome once it is handled.
+func trackHandle(sub *subscription) func(err error) {
+	start := time.Now()
+	InFlightGauge.Inc(sub.subject)
+	return func(err error) {
+		InFlightGauge.Dec(sub.subject)
+		result := outcome(err)
+		MessagesCounter.Inc(sub.subject, OpHandle, result)
+		HandleLatency.Since(start, sub.subject, result)
+	}
+}
+
+func recordRedelivery(sub *subscription, m *nats.Msg) {
+	if meta, err := m.Metadata(); err == nil && meta.NumDelivered > 1 {
+		RedeliveryCounter.Inc(sub.subject)
+	}
+}
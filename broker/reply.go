@@ -0,0 +1,81 @@
+package broker
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/soffa-io/soffa-core-go/h"
+)
+
+const (
+	HeaderError        = "X-Error"
+	HeaderErrorCode    = "X-Error-Code"
+	HeaderErrorMessage = "X-Error-Message"
+
+	errorFunctional   = "functional"
+	errorTechnical    = "technical"
+	errorUnauthorized = "unauthorized"
+)
+
+// WithTimeout bounds the time spent waiting for a reply (or a JetStream ack),
+// the deadline of the context passed to RequestCtx/PublishCtx still applies.
+func WithTimeout(timeout time.Duration) PublishOption {
+	return func(msg *Message) {
+		msg.timeout = timeout
+	}
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// errorReply describes the failure of a handler so that the requester gets a
+// typed error instead of an empty response.
+func errorReply(err error) Message {
+	kind, code := errorTechnical, "TERR"
+	var functional errors.ErrFunctional
+	var technical errors.ErrTechnical
+	var unauthorized errors.ErrUnauthorized
+	if errors.As(err, &functional) {
+		kind, code = errorFunctional, functional.Code
+	} else if errors.As(err, &technical) {
+		kind, code = errorTechnical, technical.Code
+	}
+	message := err.Error()
+	if errors.As(err, &unauthorized) {
+		kind, code = errorUnauthorized, ""
+		message = strings.TrimSuffix(message, ": "+unauthorized.Error())
+	} else {
+		message = strings.TrimSuffix(message, ": "+code)
+	}
+	return Message{Headers: map[string]string{
+		HeaderError:        kind,
+		HeaderErrorCode:    code,
+		HeaderErrorMessage: message,
+	}}
+}
+
+// replyError returns the error carried by a reply, nil for a regular response.
+func replyError(reply Message) error {
+	kind := reply.Header(HeaderError)
+	if h.IsStrEmpty(kind) {
+		return nil
+	}
+	code, message := reply.Header(HeaderErrorCode), reply.Header(HeaderErrorMessage)
+	switch kind {
+	case errorFunctional:
+		return errors.NewFunctionalError(code, message)
+	case errorUnauthorized:
+		return errors.NewUnauthorizedError(message)
+	default:
+		return errors.NewTechnicalError(h.AnyStr(code, "TERR"), message)
+	}
+}
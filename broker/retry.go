@@ -0,0 +1,128 @@
+package broker
+
+import (
+	"math"
+	"time"
+
+	"github.com/soffa-io/soffa-core-go/errors"
+)
+
+// RetryPolicy tells how a failing handler is retried before its message is
+// moved to a dead-letter subject.
+//
+// Retryable lists the errors worth retrying, matched by type: ErrTechnical{}
+// matches every technical error and ErrFunctional{Code: "X"} only matches the
+// functional errors with code X. When empty, every error but functional ones
+// is retried. DeadLetter overrides the default "dlq.<service>.<subject>".
+type RetryPolicy struct {
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+	Retryable    []error
+	DeadLetter   string
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:  3,
+		InitialDelay: 500 * time.Millisecond,
+		MaxDelay:     30 * time.Second,
+		Multiplier:   2,
+	}
+}
+
+func (p RetryPolicy) IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if len(p.Retryable) == 0 {
+		return !errors.IsFunctionalErr(err)
+	}
+	for _, candidate := range p.Retryable {
+		if matchError(err, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// Delay returns the backoff to apply after the given (1-based) attempt.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := time.Duration(float64(p.InitialDelay) * math.Pow(multiplier, float64(attempt-1)))
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+func matchError(err error, candidate error) bool {
+	switch c := candidate.(type) {
+	case errors.ErrTechnical:
+		var target errors.ErrTechnical
+		return errors.As(err, &target) && (c.Code == "" || c.Code == target.Code)
+	case errors.ErrFunctional:
+		var target errors.ErrFunctional
+		return errors.As(err, &target) && (c.Code == "" || c.Code == target.Code)
+	default:
+		return errors.Is(err, candidate)
+	}
+}
+
+// retryAfter tells whether the failed attempt (1-based) of a handler is retried,
+// and after which delay.
+func (p *RetryPolicy) retryAfter(err error, attempt int) (time.Duration, bool) {
+	if p == nil || attempt >= p.MaxAttempts || !p.IsRetryable(err) {
+		return 0, false
+	}
+	return p.Delay(attempt), true
+}
+
+// invoke runs the handler, retrying it in place according to policy. It is only
+// used by the mock broker which delivers synchronously, nats redelivers the
+// message instead (see NatsMessageClient.handle).
+func invoke(handler Handler, msg Message, policy *RetryPolicy) (interface{}, int, error) {
+	attempt := 0
+	for {
+		attempt++
+		response, _, err := invokeOnce(handler, msg)
+		if err == nil {
+			return response, attempt, nil
+		}
+		delay, retry := policy.retryAfter(err, attempt)
+		if !retry {
+			return nil, attempt, err
+		}
+		time.Sleep(delay)
+	}
+}
+
+// invokeOnce runs the handler, it fails when the handler panics (panicked is
+// then true) or returns an error.
+func invokeOnce(handler Handler, msg Message) (response interface{}, panicked bool, err error) {
+	return safeInvoke(handler, msg.withTenant())
+}
+
+func safeInvoke(handler Handler, msg Message) (response interface{}, panicked bool, err error) {
+	defer func() {
+		if re := recover(); re != nil {
+			response, panicked, err = nil, true, recoveredError(re)
+		}
+	}()
+	response = handler(msg)
+	if e, ok := response.(error); ok {
+		return nil, false, e
+	}
+	return response, false, nil
+}
+
+func recoveredError(re interface{}) error {
+	if err, ok := re.(error); ok {
+		return err
+	}
+	return errors.Errorf("%v", re)
+}
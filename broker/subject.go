@@ -0,0 +1,39 @@
+package broker
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	regexDurableName = regexp.MustCompile("[^a-zA-Z0-9_-]")
+)
+
+// MatchSubject tells whether subject is covered by pattern according to the
+// nats subject rules: "*" matches exactly one token and ">" matches one or
+// more trailing tokens. Subject may itself contain wildcards, in which case
+// the pattern must cover every subject the wildcard could match.
+func MatchSubject(pattern string, subject string) bool {
+	pt := strings.Split(pattern, ".")
+	st := strings.Split(subject, ".")
+	for i, p := range pt {
+		if p == ">" {
+			return len(st) > i
+		}
+		if i >= len(st) {
+			return false
+		}
+		if st[i] == ">" {
+			return false
+		}
+		if p != "*" && p != st[i] {
+			return false
+		}
+	}
+	return len(pt) == len(st)
+}
+
+func durableName(name string, subject string) string {
+	subject = strings.NewReplacer("*", "STAR", ">", "ALL").Replace(subject)
+	return regexDurableName.ReplaceAllString(name+"_"+subject, "_")
+}
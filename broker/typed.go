@@ -0,0 +1,67 @@
+package broker
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/soffa-io/soffa-core-go/h"
+)
+
+const ErrValidationCode = "FVAL"
+
+type messageKey struct{}
+
+// MessageFrom returns the message being handled by a typed handler.
+func MessageFrom(ctx context.Context) (Message, bool) {
+	msg, ok := ctx.Value(messageKey{}).(Message)
+	return msg, ok
+}
+
+func withMessage(ctx context.Context, msg Message) context.Context {
+	return context.WithValue(ctx, messageKey{}, msg)
+}
+
+// On subscribes a typed handler to subject. The payload is decoded into T and
+// validated with the `binding` tags used by the http handlers, invalid payloads
+// are rejected with an ErrFunctional (code FVAL). R is sent back to requesters.
+func On[T any, R any](client Client, subject string, handler func(ctx context.Context, payload T) (R, error), opts ...SubscribeOption) {
+	client.Subscribe(subject, Typed(handler), opts...)
+}
+
+// OnQueue is the queue group flavour of On.
+func OnQueue[T any, R any](client Client, subject string, group string, handler func(ctx context.Context, payload T) (R, error), opts ...SubscribeOption) {
+	client.SubscribeQueue(subject, group, Typed(handler), opts...)
+}
+
+// OnEvent subscribes a typed handler that does not reply.
+func OnEvent[T any](client Client, subject string, handler func(ctx context.Context, payload T) error, opts ...SubscribeOption) {
+	client.Subscribe(subject, Typed(func(ctx context.Context, payload T) (interface{}, error) {
+		return nil, handler(ctx, payload)
+	}), opts...)
+}
+
+// Typed adapts a typed handler to a Handler.
+func Typed[T any, R any](handler func(ctx context.Context, payload T) (R, error)) Handler {
+	return func(msg Message) interface{} {
+		payload := Decode[T](msg)
+		response, err := handler(withMessage(msg.Context(), msg), payload)
+		errors.Raise(err)
+		return response
+	}
+}
+
+// Decode decodes and validates the payload of msg, it panics with a validation
+// error when the payload is invalid.
+func Decode[T any](msg Message) T {
+	var payload T
+	if err := msg.Decode(&payload); err != nil {
+		errors.Raise(errors.NewFunctionalError(ErrValidationCode, "invalid payload: "+err.Error()))
+	}
+	if !h.IsNil(payload) {
+		if err := binding.Validator.ValidateStruct(payload); err != nil {
+			errors.Raise(errors.NewFunctionalError(ErrValidationCode, err.Error()))
+		}
+	}
+	return payload
+}
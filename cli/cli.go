@@ -1,12 +1,15 @@
 package cli
 
 import (
+	"fmt"
 	"github.com/soffa-io/soffa-core-go"
+	"github.com/soffa-io/soffa-core-go/db"
 	"github.com/soffa-io/soffa-core-go/h"
 	"github.com/soffa-io/soffa-core-go/log"
 	"github.com/spf13/cobra"
 	"net"
 	"os"
+	"text/tabwriter"
 )
 
 func Execute(name string, version string, createApp func(env string) *soffa.App) {
@@ -17,6 +20,10 @@ func Execute(name string, version string, createApp func(env string) *soffa.App)
 	}
 	rootCmd.AddCommand(createServerCmd(createApp))
 	rootCmd.AddCommand(createDbCommand(createApp))
+	rootCmd.AddCommand(createDbStatusCommand(createApp))
+	rootCmd.AddCommand(createDbRollbackCommand(createApp))
+	rootCmd.AddCommand(createDbDryRunCommand(createApp))
+	rootCmd.AddCommand(createDbCreateCommand())
 	_ = rootCmd.Execute()
 }
 
@@ -69,8 +76,108 @@ func createDbCommand(createApp func(env string) *soffa.App) *cobra.Command {
 			app.MigrateDB()
 		},
 	}
-	cmd.Flags().StringVarP(&configSource, "config", "c", os.Getenv("CONFIG_SOURCE"), "config source")
-	cmd.Flags().StringVarP(&envName, "env", "e", h.Getenv("ENV", "prod"), "active environment profile")
+	addDbFlags(cmd, &configSource, &envName)
+
+	return cmd
+}
+
+func addDbFlags(cmd *cobra.Command, configSource *string, envName *string) {
+	cmd.Flags().StringVarP(configSource, "config", "c", os.Getenv("CONFIG_SOURCE"), "config source")
+	cmd.Flags().StringVarP(envName, "env", "e", h.Getenv("ENV", "prod"), "active environment profile")
+}
+
+func createDbStatusCommand(createApp func(env string) *soffa.App) *cobra.Command {
+	var configSource string
+	var envName string
+
+	cmd := &cobra.Command{
+		Use:   "persistence:status",
+		Short: "Show the applied and pending database migrations",
+		Run: func(cmd *cobra.Command, args []string) {
+			app := createApp(envName)
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			_, _ = fmt.Fprintln(w, "DATASOURCE\tTENANT\tMIGRATION\tSTATUS")
+			for _, s := range app.MigrationStatus() {
+				status := "pending"
+				if s.Unknown {
+					status = "applied (unknown)"
+				} else if s.Applied {
+					status = "applied"
+				}
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Datasource, s.Tenant, s.Id, status)
+			}
+			_ = w.Flush()
+		},
+	}
+	addDbFlags(cmd, &configSource, &envName)
+	return cmd
+}
 
+func createDbRollbackCommand(createApp func(env string) *soffa.App) *cobra.Command {
+	var configSource string
+	var envName string
+	var ds string
+	var to string
+
+	cmd := &cobra.Command{
+		Use:   "persistence:rollback",
+		Short: "Roll back the last database migration, or the ones applied after --to",
+		Run: func(cmd *cobra.Command, args []string) {
+			app := createApp(envName)
+			app.RollbackDB(ds, to)
+		},
+	}
+	addDbFlags(cmd, &configSource, &envName)
+	cmd.Flags().StringVar(&ds, "ds", "", "datasource id (all by default)")
+	cmd.Flags().StringVar(&to, "to", "", "id of the migration to roll back to (kept)")
+	return cmd
+}
+
+func createDbDryRunCommand(createApp func(env string) *soffa.App) *cobra.Command {
+	var configSource string
+	var envName string
+
+	cmd := &cobra.Command{
+		Use:   "persistence:dry-run",
+		Short: "Show the database migrations that would be applied",
+		Run: func(cmd *cobra.Command, args []string) {
+			app := createApp(envName)
+			pending := app.DryRunMigrations()
+			out := cmd.OutOrStdout()
+			if len(pending) == 0 {
+				_, _ = fmt.Fprintln(out, "no pending migrations")
+			}
+			for _, m := range pending {
+				target := m.Datasource
+				if m.Tenant != "" {
+					target += "/" + m.Tenant
+				}
+				_, _ = fmt.Fprintf(out, "-- [%s] %s\n", target, m.Id)
+				if m.Sql == "" {
+					_, _ = fmt.Fprintln(out, "-- (go migration)")
+				} else {
+					_, _ = fmt.Fprintln(out, m.Sql)
+				}
+			}
+		},
+	}
+	addDbFlags(cmd, &configSource, &envName)
+	return cmd
+}
+
+func createDbCreateCommand() *cobra.Command {
+	var dir string
+
+	cmd := &cobra.Command{
+		Use:   "persistence:create <name>",
+		Short: "Create the up and down files of a new sql migration",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			up, down := db.CreateMigrationFiles(dir, args[0])
+			_, _ = fmt.Fprintln(cmd.OutOrStdout(), up)
+			_, _ = fmt.Fprintln(cmd.OutOrStdout(), down)
+		},
+	}
+	cmd.Flags().StringVarP(&dir, "dir", "d", "migrations", "migrations directory")
 	return cmd
 }
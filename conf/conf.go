@@ -7,7 +7,9 @@ import (
 	"github.com/soffa-io/soffa-core-go/h"
 	"github.com/soffa-io/soffa-core-go/log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Manager struct {
@@ -117,3 +119,45 @@ func (m *Manager) Get(paths ...string) string {
 	}
 	return ""
 }
+
+func (m *Manager) GetInt(fallback int, paths ...string) int {
+	value := m.Get(paths...)
+	if h.IsStrEmpty(value) {
+		return fallback
+	}
+	iv, err := strconv.Atoi(value)
+	if err != nil {
+		log.Default.Warnf("[config] invalid integer value for %s: %s", strings.Join(paths, ","), value)
+		return fallback
+	}
+	return iv
+}
+
+func (m *Manager) GetBool(fallback bool, paths ...string) bool {
+	value := m.Get(paths...)
+	if h.IsStrEmpty(value) {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Default.Warnf("[config] invalid boolean value for %s: %s", strings.Join(paths, ","), value)
+		return fallback
+	}
+	return b
+}
+
+// GetDuration accepts either a Go duration ("30s", "1m") or a number of seconds.
+func (m *Manager) GetDuration(fallback time.Duration, paths ...string) time.Duration {
+	value := m.Get(paths...)
+	if h.IsStrEmpty(value) {
+		return fallback
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return d
+	}
+	if s, err := strconv.Atoi(value); err == nil {
+		return time.Duration(s) * time.Second
+	}
+	log.Default.Warnf("[config] invalid duration value for %s: %s", strings.Join(paths, ","), value)
+	return fallback
+}
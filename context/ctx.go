@@ -19,6 +19,28 @@ func New() *Ctx {
 	return &Ctx{c: context.Background()}
 }
 
+func From(ctx context.Context) *Ctx {
+	return &Ctx{c: ctx}
+}
+
+func (c *Ctx) SetTenant(tenant string) *Ctx {
+	c.c = WithTenant(c.c, tenant)
+	return c
+}
+
+func (c *Ctx) Tenant() string {
+	return TenantFrom(c.c)
+}
+
 func (c *Ctx) Unwrap() context.Context {
 	return c.c
 }
+
+func (c *Ctx) SetPrincipal(principal string) *Ctx {
+	c.c = WithPrincipal(c.c, principal)
+	return c
+}
+
+func (c *Ctx) Principal() string {
+	return PrincipalFrom(c.c)
+}
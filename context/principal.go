@@ -0,0 +1,20 @@
+package context
+
+import "context"
+
+type principalKey struct{}
+
+// WithPrincipal attaches the authenticated user of the request to ctx, db
+// fills the audit fields (see db.AuditModel) with it.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the principal attached to ctx, if any.
+func PrincipalFrom(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
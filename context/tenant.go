@@ -0,0 +1,20 @@
+package context
+
+import "context"
+
+type tenantKey struct{}
+
+// WithTenant attaches the tenant of the request (or message) being handled to
+// ctx, db.LinkFrom routes the queries to it.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFrom returns the tenant attached to ctx, if any.
+func TenantFrom(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
@@ -0,0 +1,245 @@
+package counters
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/soffa-io/soffa-core-go/conf"
+)
+
+// Vectors are labelled metrics (subject, outcome, ...). Like Counter, they are
+// exported once prometheus is enabled and keep local values for tests.
+
+var (
+	__vectors = map[string]interface{}{}
+	__mu      sync.Mutex
+)
+
+func register(code string, create func() interface{}) interface{} {
+	__mu.Lock()
+	defer __mu.Unlock()
+	if existing, ok := __vectors[code]; ok {
+		return existing
+	}
+	vector := create()
+	__vectors[code] = vector
+	return vector
+}
+
+func key(values []string) string {
+	return strings.Join(values, "\x1f")
+}
+
+type CounterVec struct {
+	code   string
+	desc   string
+	labels []string
+	mu     sync.Mutex
+	counts map[string]int64
+	pm     *prometheus.CounterVec
+}
+
+func NewCounterVec(code string, desc string, labels ...string) *CounterVec {
+	return register(code, func() interface{} {
+		return &CounterVec{code: code, desc: desc, labels: labels, counts: map[string]int64{}}
+	}).(*CounterVec)
+}
+
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *CounterVec) Add(delta int64, values ...string) {
+	c.mu.Lock()
+	c.counts[key(values)] += delta
+	if c.pm == nil && conf.PrometheusEnabled {
+		c.pm = promauto.NewCounterVec(prometheus.CounterOpts{Name: c.code, Help: c.desc}, c.labels)
+	}
+	pm := c.pm
+	c.mu.Unlock()
+	if pm != nil {
+		pm.WithLabelValues(values...).Add(float64(delta))
+	}
+}
+
+func (c *CounterVec) Value(values ...string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[key(values)]
+}
+
+func (c *CounterVec) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts = map[string]int64{}
+}
+
+type Gauge struct {
+	code   string
+	desc   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]float64
+	pm     *prometheus.GaugeVec
+}
+
+func NewGauge(code string, desc string, labels ...string) *Gauge {
+	return register(code, func() interface{} {
+		return &Gauge{code: code, desc: desc, labels: labels, values: map[string]float64{}}
+	}).(*Gauge)
+}
+
+func (g *Gauge) Inc(values ...string) {
+	g.Add(1, values...)
+}
+
+func (g *Gauge) Dec(values ...string) {
+	g.Add(-1, values...)
+}
+
+func (g *Gauge) Add(delta float64, values ...string) {
+	g.mu.Lock()
+	g.values[key(values)] += delta
+	if g.pm == nil && conf.PrometheusEnabled {
+		g.pm = promauto.NewGaugeVec(prometheus.GaugeOpts{Name: g.code, Help: g.desc}, g.labels)
+	}
+	pm := g.pm
+	g.mu.Unlock()
+	if pm != nil {
+		pm.WithLabelValues(values...).Add(delta)
+	}
+}
+
+func (g *Gauge) Value(values ...string) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.values[key(values)]
+}
+
+// Histogram observes durations (in seconds), buckets default to the prometheus
+// ones (5ms to 10s).
+type Histogram struct {
+	code    string
+	desc    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	counts  map[string]int64
+	sums    map[string]time.Duration
+	pm      *prometheus.HistogramVec
+}
+
+func NewHistogram(code string, desc string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
+	return register(code, func() interface{} {
+		return &Histogram{
+			code: code, desc: desc, labels: labels, buckets: buckets,
+			counts: map[string]int64{}, sums: map[string]time.Duration{},
+		}
+	}).(*Histogram)
+}
+
+func (h *Histogram) Observe(d time.Duration, values ...string) {
+	h.mu.Lock()
+	k := key(values)
+	h.counts[k]++
+	h.sums[k] += d
+	if h.pm == nil && conf.PrometheusEnabled {
+		h.pm = promauto.NewHistogramVec(prometheus.HistogramOpts{Name: h.code, Help: h.desc, Buckets: h.buckets}, h.labels)
+	}
+	pm := h.pm
+	h.mu.Unlock()
+	if pm != nil {
+		pm.WithLabelValues(values...).Observe(d.Seconds())
+	}
+}
+
+// Since observes the time elapsed since start.
+func (h *Histogram) Since(start time.Time, values ...string) {
+	h.Observe(time.Since(start), values...)
+}
+
+func (h *Histogram) Count(values ...string) int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.counts[key(values)]
+}
+
+func (h *Histogram) Sum(values ...string) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sums[key(values)]
+}
+
+// Sampled is a labelled gauge whose values are read from callbacks when the
+// metrics are collected (connection pool statistics, queue sizes, ...).
+type Sampled struct {
+	desc    *prometheus.Desc
+	mu      sync.Mutex
+	sources map[string]sampledSource
+}
+
+type sampledSource struct {
+	values []string
+	fn     func() float64
+}
+
+func NewSampled(code string, desc string, labels ...string) *Sampled {
+	return register(code, func() interface{} {
+		s := &Sampled{
+			desc:    prometheus.NewDesc(code, desc, labels, nil),
+			sources: map[string]sampledSource{},
+		}
+		// unchecked collector: nothing is described, values are only collected
+		// once prometheus is enabled
+		_ = prometheus.DefaultRegisterer.Register(s)
+		return s
+	}).(*Sampled)
+}
+
+// Watch reads the value for the given labels from fn, it replaces the previous
+// callback for the same labels.
+func (s *Sampled) Watch(fn func() float64, values ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sources[key(values)] = sampledSource{values: values, fn: fn}
+}
+
+func (s *Sampled) Unwatch(values ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sources, key(values))
+}
+
+func (s *Sampled) Value(values ...string) float64 {
+	s.mu.Lock()
+	source, ok := s.sources[key(values)]
+	s.mu.Unlock()
+	if !ok {
+		return 0
+	}
+	return source.fn()
+}
+
+func (s *Sampled) Describe(chan<- *prometheus.Desc) {
+}
+
+func (s *Sampled) Collect(ch chan<- prometheus.Metric) {
+	if !conf.PrometheusEnabled {
+		return
+	}
+	s.mu.Lock()
+	sources := make([]sampledSource, 0, len(s.sources))
+	for _, source := range s.sources {
+		sources = append(sources, source)
+	}
+	s.mu.Unlock()
+	for _, source := range sources {
+		ch <- prometheus.MustNewConstMetric(s.desc, prometheus.GaugeValue, source.fn(), source.values...)
+	}
+}
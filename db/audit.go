@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/soffa-io/soffa-core-go/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	CreatedByColumn = "created_by"
+	UpdatedByColumn = "updated_by"
+	VersionColumn   = "version"
+
+	versionedKey    = "soffa:versioned"
+	keepCreationKey = "soffa:keep_creation"
+	createdAtColumn = "created_at"
+)
+
+// AuditModel is embedded by the models that record when and by whom they were
+// created and last updated, the users are the principal of the link (see
+// Link.Principal and LinkFrom).
+type AuditModel struct {
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	CreatedBy string    `gorm:"size:255" json:"createdBy,omitempty"`
+	UpdatedBy string    `gorm:"size:255" json:"updatedBy,omitempty"`
+}
+
+// SoftDeleteModel is embedded by the models that are flagged as deleted instead
+// of being deleted, Find, Count and Exists ignore them.
+type SoftDeleteModel struct {
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// VersionedModel is embedded by the models updated with optimistic locking,
+// Save fails with ErrConflictCode when the row was updated in the meantime.
+type VersionedModel struct {
+	Version int64 `gorm:"not null" json:"version"`
+}
+
+// Model combines AuditModel, SoftDeleteModel and VersionedModel.
+type Model struct {
+	AuditModel
+	SoftDeleteModel
+	VersionedModel
+}
+
+type principalKey struct{}
+
+// registerAudit installs the callbacks that stamp the audit fields and check
+// the version of the models.
+func registerAudit(conn *gorm.DB) {
+	cb := conn.Callback()
+	errors.Raise(
+		cb.Create().Before("gorm:create").Register("soffa:audit_create", func(db *gorm.DB) {
+			restoreCreation(db)
+			stampPrincipal(db, CreatedByColumn, UpdatedByColumn)
+			initVersion(db)
+		}),
+		cb.Update().Before("gorm:update").Register("soffa:audit_update", func(db *gorm.DB) {
+			stampPrincipal(db, UpdatedByColumn)
+			keepCreation(db)
+			lockVersion(db)
+		}),
+		cb.Update().After("gorm:update").Register("soffa:version_check", checkVersion),
+	)
+}
+
+func withPrincipal(conn *gorm.DB, principal string) *gorm.DB {
+	return conn.WithContext(context.WithValue(conn.Statement.Context, principalKey{}, principal))
+}
+
+// eachModel calls fn with the models of the statement.
+func eachModel(db *gorm.DB, fn func(rv reflect.Value)) {
+	rv := db.Statement.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			fn(reflect.Indirect(rv.Index(i)))
+		}
+	case reflect.Struct:
+		fn(rv)
+	}
+}
+
+func stampPrincipal(db *gorm.DB, columns ...string) {
+	if db.Statement.Context == nil || db.Statement.Schema == nil {
+		return
+	}
+	principal, _ := db.Statement.Context.Value(principalKey{}).(string)
+	if principal == "" {
+		return
+	}
+	for _, column := range columns {
+		if field := db.Statement.Schema.LookUpField(column); field != nil {
+			eachModel(db, func(rv reflect.Value) {
+				_ = db.AddError(field.Set(rv, principal))
+			})
+		}
+	}
+}
+
+// keepCreation prevents the updates (Save included) from overwriting when and
+// by whom the model was created.
+func keepCreation(db *gorm.DB) {
+	if db.Statement.Schema != nil && db.Statement.Schema.LookUpField(CreatedByColumn) != nil {
+		db.Statement.Omits = append(db.Statement.Omits, CreatedByColumn, createdAtColumn)
+		db.InstanceSet(keepCreationKey, len(db.Statement.Omits))
+	}
+}
+
+// restoreCreation removes the columns omitted by keepCreation when Save
+// inserts the model after the update, the statement is reused.
+func restoreCreation(db *gorm.DB) {
+	if n, ok := db.InstanceGet(keepCreationKey); ok && n.(int) == len(db.Statement.Omits) {
+		db.Statement.Omits = db.Statement.Omits[:n.(int)-2]
+	}
+}
+
+func versionField(db *gorm.DB) *schema.Field {
+	if db.Statement.Schema == nil {
+		return nil
+	}
+	return db.Statement.Schema.LookUpField(VersionColumn)
+}
+
+func initVersion(db *gorm.DB) {
+	field := versionField(db)
+	if field == nil {
+		return
+	}
+	eachModel(db, func(rv reflect.Value) {
+		if _, zero := field.ValueOf(rv); zero {
+			_ = db.AddError(field.Set(rv, 1))
+		}
+	})
+}
+
+// lockVersion restricts the update of a model to the version it was read with
+// and increments it. The models never saved (version 0) are not checked, Save
+// inserts them.
+func lockVersion(db *gorm.DB) {
+	field := versionField(db)
+	rv := db.Statement.ReflectValue
+	if field == nil || rv.Kind() != reflect.Struct || db.Statement.Dest != db.Statement.Model {
+		return
+	}
+	value, zero := field.ValueOf(rv)
+	if zero {
+		return
+	}
+	version := reflect.ValueOf(value).Int()
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: version},
+	}})
+	db.InstanceSet(versionedKey, version)
+	_ = db.AddError(field.Set(rv, version+1))
+}
+
+func checkVersion(db *gorm.DB) {
+	value, ok := db.InstanceGet(versionedKey)
+	if !ok || (db.Error == nil && db.RowsAffected > 0) {
+		return
+	}
+	// the model keeps the version it was read with
+	_ = versionField(db).Set(db.Statement.ReflectValue, value)
+	if db.Error != nil {
+		return
+	}
+	_ = db.AddError(errors.NewFunctionalError(errors.ErrConflictCode,
+		fmt.Sprintf("%s was updated or deleted by another transaction", db.Statement.Schema.Name)))
+}
@@ -0,0 +1,150 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/soffa-io/soffa-core-go/errors"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/driver/sqlserver"
+	"gorm.io/gorm"
+)
+
+// Tenancy strategies, a dialect isolates the tenants of a datasource in a
+// schema each when it supports it, in a database each otherwise.
+const (
+	TenancyNone     = "none"
+	TenancySchema   = "schema"
+	TenancyDatabase = "database"
+)
+
+type dialect struct {
+	name       string
+	tenancy    string
+	skipLocked bool
+	open       func(dsn string) gorm.Dialector
+	// statements use %[1]s for the quoted name and %[2]s for the raw one
+	createSchema string
+	dropSchema   string
+	useSchema    string
+	// schemaExists counts the schemas (or databases) named %[2]s
+	schemaExists string
+	// currentSchema returns the database selected by the connection, it is
+	// restored after a tenant operation with database tenancy.
+	currentSchema string
+	// listTables returns the tables of the current schema
+	listTables string
+}
+
+var dialects = map[string]*dialect{
+	"sqlite3": {
+		name:       "sqlite",
+		tenancy:    TenancyNone,
+		open:       sqlite.Open,
+		listTables: "SELECT name FROM sqlite_master WHERE type = 'table'",
+	},
+	"postgres": {
+		name:         "postgres",
+		tenancy:      TenancySchema,
+		skipLocked:   true,
+		open:         postgres.Open,
+		createSchema: "CREATE SCHEMA IF NOT EXISTS %[1]s",
+		dropSchema:   "DROP SCHEMA IF EXISTS %[1]s CASCADE",
+		// reset at the end of the transaction, the pooled connection keeps the
+		// default schema
+		useSchema:    "SET LOCAL search_path TO %[1]s",
+		schemaExists: "SELECT COUNT(*) FROM information_schema.schemata WHERE schema_name = '%[2]s'",
+		listTables:   "SELECT table_name FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA()",
+	},
+	"mysql": {
+		name:       "mysql",
+		tenancy:    TenancyDatabase,
+		skipLocked: true,
+		open: func(dsn string) gorm.Dialector {
+			// scan DATETIME columns into time.Time
+			dsn = withDefaultParam(dsn, "parseTime", "true")
+			// report the matched rows, an update with the same values is not a
+			// missing row
+			dsn = withDefaultParam(dsn, "clientFoundRows", "true")
+			return mysql.Open(dsn)
+		},
+		createSchema:  "CREATE DATABASE IF NOT EXISTS %[1]s",
+		dropSchema:    "DROP DATABASE IF EXISTS %[1]s",
+		useSchema:     "USE %[1]s",
+		schemaExists:  "SELECT COUNT(*) FROM information_schema.schemata WHERE schema_name = '%[2]s'",
+		currentSchema: "SELECT DATABASE()",
+		listTables:    "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE()",
+	},
+	"sqlserver": {
+		name:          "sqlserver",
+		tenancy:       TenancyDatabase,
+		open:          sqlserver.Open,
+		createSchema:  "IF DB_ID(N'%[2]s') IS NULL CREATE DATABASE %[1]s",
+		dropSchema:    "IF DB_ID(N'%[2]s') IS NOT NULL DROP DATABASE %[1]s",
+		useSchema:     "USE %[1]s",
+		schemaExists:  "SELECT COUNT(*) FROM sys.databases WHERE name = N'%[2]s'",
+		currentSchema: "SELECT DB_NAME()",
+		listTables:    "SELECT name FROM sys.tables",
+	},
+}
+
+// withDefaultParam adds the param key=value to dsn unless it is already set.
+func withDefaultParam(dsn string, key string, value string) string {
+	if strings.Contains(dsn, key+"=") {
+		return dsn
+	}
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return dsn + sep + key + "=" + value
+}
+
+func lookupDialect(driver string) *dialect {
+	d, ok := dialects[driver]
+	if !ok {
+		errors.RaiseNew("Unsupported database dialect: %s", driver)
+	}
+	return d
+}
+
+func (d *dialect) supportsTenancy() bool {
+	return d.tenancy != TenancyNone
+}
+
+func (d *dialect) statement(conn *gorm.DB, format string, name string) string {
+	var quoted strings.Builder
+	conn.Dialector.QuoteTo(&quoted, name)
+	return fmt.Sprintf(format, quoted.String(), strings.ReplaceAll(name, "'", "''"))
+}
+
+func (d *dialect) create(conn *gorm.DB, name string) error {
+	return conn.Exec(d.statement(conn, d.createSchema, name)).Error
+}
+
+func (d *dialect) drop(conn *gorm.DB, name string) error {
+	return conn.Exec(d.statement(conn, d.dropSchema, name)).Error
+}
+
+func (d *dialect) use(conn *gorm.DB, name string) error {
+	return conn.Exec(d.statement(conn, d.useSchema, name)).Error
+}
+
+func (d *dialect) exists(conn *gorm.DB, name string) (bool, error) {
+	var count int64
+	err := conn.Raw(d.statement(conn, d.schemaExists, name)).Scan(&count).Error
+	return count > 0, err
+}
+
+// current returns the database selected by conn, if the dialect switches
+// databases.
+func (d *dialect) current(conn *gorm.DB) (string, error) {
+	if d.currentSchema == "" {
+		return "", nil
+	}
+	var name string
+	err := conn.Raw(d.currentSchema).Scan(&name).Error
+	return name, err
+}
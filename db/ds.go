@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"fmt"
 	"github.com/go-gormigrate/gormigrate/v2"
 	"github.com/soffa-io/soffa-core-go/counters"
@@ -8,55 +9,172 @@ import (
 	"github.com/soffa-io/soffa-core-go/h"
 	"github.com/soffa-io/soffa-core-go/log"
 	"github.com/xo/dburl"
-	"gorm.io/driver/postgres"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/schema"
+	"io/fs"
 )
 
 type DS struct {
-	order             int
-	serviceName       string
-	Id                string
-	Url               string
-	TablePrefix       string
-	Migrations        []*gormigrate.Migration
-	TenantsLoader     TenantsLoader
+	order       int
+	serviceName string
+	Id          string
+	Url         string
+	TablePrefix string
+	Migrations  []*gormigrate.Migration
+	// MigrationFiles holds versioned <id>.up.sql and <id>.down.sql files
+	// (e.g. an embed.FS), applied in id order after Migrations.
+	MigrationFiles fs.FS
+	TenantsLoader  TenantsLoader
+	// TenantRegistry keeps the tenants in the soffa_tenants table, they are
+	// migrated like the ones of TenantsLoader (see Link.ProvisionTenant).
+	TenantRegistry bool
+	// Isolation of the tenants, IsolationSchema (default) or IsolationRow.
+	Isolation string
+	// RowLevelSecurity also isolates the tenants with postgres RLS policies
+	// (see EnableRowLevelSecurity), with IsolationRow.
+	RowLevelSecurity bool
+	// MigrationWorkers is the number of tenants migrated in parallel (4 by
+	// default).
+	MigrationWorkers int
+	Outbox           bool
+	Inbox            bool
+	// Replicas are read-only copies, reads outside of transactions are spread
+	// over them (see Link.Primary).
+	Replicas          []string
+	Pool              Pool
 	link              *Link
+	system            *Link
+	dialect           *dialect
+	defaultSchema     string
+	tenants           *tenantCache
+	tenantListener    func(event TenantEvent)
 	counterMigrations *counters.Counter
 	counterOperations *counters.Counter
 }
 
-func (ds *DS) ping() error {
-	return ds.link.Ping()
+func (ds *DS) ping(ctx context.Context) error {
+	return ds.system.PingContext(ctx)
+}
+
+func (ds *DS) close() error {
+	if ds.link == nil {
+		return nil
+	}
+	return ds.link.Close()
 }
 
 func (ds *DS) migrate() {
 	ds.migrateSchema("")
 }
 
+// migrations returns the migrations of the datasource, the ones of the enabled
+// subsystems (outbox, inbox) first.
+func (ds *DS) migrations() []*gormigrate.Migration {
+	migrations := ds.builtinMigrations()
+	for _, m := range ds.userMigrations() {
+		migrations = withMigration(migrations, m)
+	}
+	return migrations
+}
+
+// builtinMigrations are the migrations of the enabled subsystems, they are
+// never rolled back (see rollback).
+func (ds *DS) builtinMigrations() []*gormigrate.Migration {
+	var migrations []*gormigrate.Migration
+	if ds.Outbox {
+		migrations = append(migrations, OutboxMigration())
+	}
+	if ds.Inbox {
+		migrations = append(migrations, InboxMigration())
+	}
+	return migrations
+}
+
+// userMigrations returns Migrations and the MigrationFiles of the datasource.
+func (ds *DS) userMigrations() []*gormigrate.Migration {
+	builtin := map[string]bool{}
+	for _, m := range ds.builtinMigrations() {
+		builtin[m.ID] = true
+	}
+	var migrations []*gormigrate.Migration
+	for _, m := range ds.Migrations {
+		if !builtin[m.ID] {
+			migrations = append(migrations, m)
+		}
+	}
+	if ds.MigrationFiles != nil {
+		for _, m := range ds.sqlMigrations() {
+			migrations = withMigration(migrations, m.migration())
+		}
+	}
+	return migrations
+}
+
+func withMigration(migrations []*gormigrate.Migration, migration *gormigrate.Migration) []*gormigrate.Migration {
+	for _, m := range migrations {
+		if m.ID == migration.ID {
+			return migrations
+		}
+	}
+	return append(append([]*gormigrate.Migration{}, migrations...), migration)
+}
+
 func (ds *DS) migrateSchema(schema string) {
-	if ds.Migrations == nil {
+	if h.IsEmpty(schema) {
+		ds.migrateRegistry()
+	}
+	migrations := ds.migrations()
+	if migrations == nil {
 		log.Default.Warn("[%s] no migrations found to apply.", ds.Id)
 		return
 	}
+	if !h.IsEmpty(schema) && ds.rowIsolation() {
+		// the tenants share the tables of the default schema
+		return
+	}
 	if !h.IsEmpty(schema) {
+		ds.validateSchema(schema)
 		log.Default.Infof("migrating schema %s", schema)
-		ds.internalMigrations(ds.Migrations, schema)
-	} else if ds.TenantsLoader != nil {
+		ds.internalMigrations(migrations, schema)
+	} else if ds.multitenant() && !ds.rowIsolation() {
 		log.Default.Info("multitenant datasource found, scanning all schemas")
-		items := ds.TenantsLoader()
-		if items == nil || len(items) == 0 {
+		tenants := ds.schemas()
+		if len(tenants) == 0 {
 			log.Default.Warn("empty tenants list received, skipping migrations")
-		} else {
-			for _, sc := range items {
-				log.Default.Infof("applying migrations on schema %s", sc)
-				ds.internalMigrations(ds.Migrations, sc)
-			}
 		}
+		ds.raiseTenantErrors(ds.migrateTenants(migrations, tenants))
 	} else {
-		ds.internalMigrations(ds.Migrations, "")
+		ds.internalMigrations(migrations, "")
+	}
+}
+
+func (ds *DS) multitenant() bool {
+	return ds.TenantsLoader != nil || ds.TenantRegistry || ds.rowIsolation()
+}
+
+// schemas returns the tenants of a multitenant datasource (from TenantsLoader
+// and the registry), or the default schema ("").
+func (ds *DS) schemas() []string {
+	if !ds.multitenant() || ds.rowIsolation() {
+		return []string{""}
+	}
+	return ds.tenantIds()
+}
+
+// tenantIds returns the tenants of TenantsLoader and the registry.
+func (ds *DS) tenantIds() []string {
+	var items []string
+	if ds.TenantsLoader != nil {
+		items = ds.TenantsLoader()
 	}
+	if ds.TenantRegistry {
+		for _, id := range ds.registeredTenants() {
+			if !h.ContainsStr(items, id) {
+				items = append(items, id)
+			}
+		}
+	}
+	return items
 }
 
 func (ds *DS) internalMigrations(migrations []*gormigrate.Migration, schema string) {
@@ -66,20 +184,7 @@ func (ds *DS) internalMigrations(migrations []*gormigrate.Migration, schema stri
 		return
 	}
 
-	ds.link.Transactional(func(tx *Link) {
-		if !h.IsEmpty(schema) && tx.supportsSchemas() {
-			tx.createSchemas(schema)
-			tx.UseSchema(schema)
-		}
-		gormLink := tx.base.(*GormLink)
-		m := gormigrate.New(gormLink.conn, &gormigrate.Options{
-			TableName:                 ds.TablePrefix + gormigrate.DefaultOptions.TableName,
-			IDColumnName:              gormigrate.DefaultOptions.IDColumnName,
-			IDColumnSize:              gormigrate.DefaultOptions.IDColumnSize,
-			UseTransaction:            gormigrate.DefaultOptions.UseTransaction,
-			ValidateUnknownMigrations: gormigrate.DefaultOptions.ValidateUnknownMigrations,
-		}, migrations)
-
+	ds.withMigrator(migrations, schema, func(m *gormigrate.Gormigrate) {
 		err := m.Migrate()
 		ds.counterOperations.Record(err)
 		ds.counterMigrations.Record(err)
@@ -89,6 +194,34 @@ func (ds *DS) internalMigrations(migrations []*gormigrate.Migration, schema stri
 
 }
 
+// withMigrator runs cb in a transaction, with a migrator working on schema.
+func (ds *DS) withMigrator(migrations []*gormigrate.Migration, schema string, cb func(m *gormigrate.Gormigrate)) {
+	tenant := !h.IsEmpty(schema) && ds.system.supportsSchemas()
+	if tenant {
+		// CREATE DATABASE is not allowed in a transaction by mysql and sqlserver
+		ds.system.createSchemas(schema)
+	}
+	ds.system.Transactional(func(tx *Link) {
+		gormLink := tx.base.(*GormLink)
+		if tenant {
+			tx.UseSchema(schema)
+			defer ds.restoreSchema(gormLink.conn)
+		}
+		m := gormigrate.New(gormLink.conn, ds.migrationOptions(), migrations)
+		cb(m)
+	})
+}
+
+func (ds *DS) migrationOptions() *gormigrate.Options {
+	return &gormigrate.Options{
+		TableName:                 ds.TablePrefix + gormigrate.DefaultOptions.TableName,
+		IDColumnName:              gormigrate.DefaultOptions.IDColumnName,
+		IDColumnSize:              gormigrate.DefaultOptions.IDColumnSize,
+		UseTransaction:            gormigrate.DefaultOptions.UseTransaction,
+		ValidateUnknownMigrations: gormigrate.DefaultOptions.ValidateUnknownMigrations,
+	}
+}
+
 func (ds *DS) bootstrap() {
 	if ds.link != nil {
 		return
@@ -97,36 +230,70 @@ func (ds *DS) bootstrap() {
 	if h.IsStrEmpty(ds.Url) {
 		errors.RaiseNew("invalid databaseUrl provided (empty)")
 	}
-	cnx, err := dburl.Parse(ds.Url)
+	link := ds.open(ds.Url)
+	if current, err := ds.dialect.current(link); err != nil {
+		errors.Raisef(err, "unable to read the current database of %s", ds.Id)
+	} else {
+		ds.defaultSchema = current
+	}
+	var replicas []*gorm.DB
+	for _, url := range ds.Replicas {
+		replicas = append(replicas, ds.open(url))
+	}
+	ds.checkIsolation()
+	if ds.rowIsolation() {
+		for _, conn := range append([]*gorm.DB{link}, replicas...) {
+			registerRowIsolation(conn)
+		}
+	}
+	registerAudit(link)
+	ds.counterMigrations = counters.NewCounter(fmt.Sprintf("x_app_%s_db_migrations", ds.serviceName), "Database migrations operations", true)
+	ds.counterOperations = counters.NewCounter(fmt.Sprintf("x_app_%s_db_operations", ds.serviceName), "Database operations", true)
+	ds.tenants = &tenantCache{}
+	ds.link = &Link{ds: ds, base: &GormLink{conn: link, replicas: replicas, next: new(uint32), ds: ds}}
+	ds.system = &Link{ds: ds, base: &GormLink{conn: link, ds: ds, system: true}}
+	ds.watchPool(link, replicas)
+}
+
+// open connects to url with the pool settings of the datasource.
+func (ds *DS) open(url string) *gorm.DB {
+	cnx, err := dburl.Parse(url)
 	if err != nil {
-		errors.Raisef(err, "error parsing databaseUrl: %s", ds.Url)
+		errors.Raisef(err, "error parsing databaseUrl: %s", url)
 	}
 
-	var dialect gorm.Dialector
-	if cnx.Driver == "sqlite3" {
-		dialect = sqlite.Open(cnx.DSN)
-	} else if cnx.Driver == "postgres" {
-		dialect = postgres.Open(cnx.DSN)
-	} else {
-		errors.RaiseNew("Unsupported database dialect: %s", cnx.Driver)
+	dialect := lookupDialect(cnx.Driver)
+	if ds.dialect == nil {
+		ds.dialect = dialect
+	} else if ds.dialect != dialect {
+		errors.RaiseNew("replica %s does not use the %s dialect of datasource %s", url, ds.dialect.name, ds.Id)
 	}
 
-	link, err := gorm.Open(dialect, &gorm.Config{
+	conn, err := gorm.Open(dialect.open(cnx.DSN), &gorm.Config{
 		NamingStrategy: schema.NamingStrategy{
 			TablePrefix: ds.TablePrefix,
 		},
 	})
 
 	if err != nil {
-		errors.Raisef(err, "conection to datasource %s failed", ds.Url)
+		errors.Raisef(err, "conection to datasource %s failed", url)
 	}
-	ds.counterMigrations = counters.NewCounter(fmt.Sprintf("x_app_%s_db_migrations", ds.serviceName), "Database migrations operations", true)
-	ds.counterOperations = counters.NewCounter(fmt.Sprintf("x_app_%s_db_operations", ds.serviceName), "Database operations", true)
-	ds.link = &Link{ds: ds, base: &GormLink{conn: link, ds: ds}}
+	ds.Pool.apply(conn)
+	return conn
 }
 
+// restoreSchema selects the default database of the datasource on conn again,
+// when tenants are isolated in databases.
+func (ds *DS) restoreSchema(conn *gorm.DB) {
+	if ds.dialect.tenancy != TenancyDatabase || h.IsEmpty(ds.defaultSchema) {
+		return
+	}
+	if err := ds.dialect.use(conn, ds.defaultSchema); err != nil {
+		log.Default.Errorf("[%s] unable to restore database %s -- %v", ds.Id, ds.defaultSchema, err)
+	}
+}
 
 func (ds *DS) Bootstrap() *Link {
 	ds.bootstrap()
 	return ds.link
-}
\ No newline at end of file
+}
@@ -1,7 +1,11 @@
 package db
 
 import (
+	"context"
 	"fmt"
+	"sync/atomic"
+
+	"github.com/soffa-io/soffa-core-go/errors"
 	"github.com/soffa-io/soffa-core-go/h"
 	"github.com/soffa-io/soffa-core-go/log"
 	"gorm.io/gorm"
@@ -9,9 +13,19 @@ import (
 
 type GormLink struct {
 	BaseLink
-	conn   *gorm.DB
-	ds     *DS
-	tenant string
+	conn     *gorm.DB
+	replicas []*gorm.DB
+	next     *uint32
+	ds       *DS
+	tenant   string
+	// system links are used internally (migrations, registry, outbox relay),
+	// they don't require a tenant on multitenant datasources
+	system bool
+	// active is set in the transactions of a link, the schema of the tenant is
+	// already selected
+	active bool
+	// principal fills the audit fields of the models
+	principal string
 }
 
 func (link *GormLink) MigrateTenant(schema string) {
@@ -24,17 +38,48 @@ func (link *GormLink) Migrate() {
 
 func (link *GormLink) WithTenant(tenant string) BaseLink {
 	return &GormLink{
-		conn:   link.conn,
-		ds:     link.ds,
-		tenant: tenant,
+		conn:      link.conn,
+		replicas:  link.replicas,
+		next:      link.next,
+		ds:        link.ds,
+		tenant:    tenant,
+		system:    link.system,
+		principal: link.principal,
 	}
 }
 
+func (link *GormLink) WithPrincipal(principal string) BaseLink {
+	clone := *link
+	clone.principal = principal
+	return &clone
+}
+
+// Primary returns a link whose reads go to the primary, to read your writes.
+func (link *GormLink) Primary() BaseLink {
+	return &GormLink{conn: link.conn, ds: link.ds, tenant: link.tenant, system: link.system, principal: link.principal}
+}
+
 func (link *GormLink) Ping() error {
+	return link.PingContext(context.Background())
+}
+
+func (link *GormLink) PingContext(ctx context.Context) error {
 	return link.withConn(func(conn *gorm.DB) error {
-		return conn.Exec("SELECT 1").Error
+		return conn.WithContext(ctx).Exec("SELECT 1").Error
 	})
+}
 
+func (link *GormLink) Close() error {
+	for _, conn := range append([]*gorm.DB{link.conn}, link.replicas...) {
+		sqlDB, err := conn.DB()
+		if err != nil {
+			return err
+		}
+		if err := sqlDB.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (link *GormLink) Create(model interface{}) error {
@@ -50,39 +95,63 @@ func (link *GormLink) Save(model interface{}) error {
 	})
 }
 
+// Update saves every field of model (zero values included) without inserting it
+// when it does not exist.
+func (link *GormLink) Update(model interface{}) (int64, error) {
+	var count int64
+	err := link.withConn(func(conn *gorm.DB) error {
+		res := conn.Model(model).Select("*").Updates(model)
+		count = res.RowsAffected
+		return res.Error
+	})
+	return count, err
+}
+
+func (link *GormLink) Delete(model interface{}, query Query) (int64, error) {
+	var count int64
+	err := link.withConn(func(conn *gorm.DB) error {
+		res := query.filter(conn).Delete(model)
+		count = res.RowsAffected
+		return res.Error
+	})
+	return count, err
+}
+
 func (link *GormLink) Exec(command string) error {
+	if err := link.checkRawSql(); err != nil {
+		return err
+	}
 	return link.withConn(func(conn *gorm.DB) error {
 		return conn.Exec(command).Error
 	})
 }
 
 func (link *GormLink) Raw(result interface{}, sql string, values ...interface{}) error {
-	return link.withConn(func(conn *gorm.DB) error {
+	if err := link.checkRawSql(); err != nil {
+		return err
+	}
+	return link.withReadConn(func(conn *gorm.DB) error {
 		return conn.Raw(sql, values...).Scan(result).Error
 	})
 }
 
 func (link *GormLink) First(model interface{}) error {
-	return link.withConn(func(conn *gorm.DB) error {
+	return link.withReadConn(func(conn *gorm.DB) error {
 		return conn.First(model).Error
 	})
 }
 
 func (link *GormLink) Pluck(table interface{}, column string, dest interface{}) error {
-	return link.withConn(func(conn *gorm.DB) error {
+	return link.withReadConn(func(conn *gorm.DB) error {
 		return conn.Model(table).Pluck(column, dest).Error
 	})
 }
 
 func (link *GormLink) Count(model interface{}, query *Query) (int64, error) {
 	var count int64 = 0
-	err := link.withConn(func(conn *gorm.DB) error {
+	err := link.withReadConn(func(conn *gorm.DB) error {
 		if query != nil {
-			if query.whereMap != nil {
-				return conn.Model(model).Where(h.UnwrapMap(*query.whereMap)).Count(&count).Error
-			} else if !h.IsEmpty(query.where) {
-				return conn.Model(model).Where(query.where, query.args...).Count(&count).Error
-			}
+			conn = query.filter(conn)
 		}
 		return conn.Model(model).Count(&count).Error
 	})
@@ -91,22 +160,18 @@ func (link *GormLink) Count(model interface{}, query *Query) (int64, error) {
 
 func (link *GormLink) CreateSchema(name string) error {
 	return link.withConn(func(conn *gorm.DB) error {
-		dialect := conn.Dialector.Name()
-		if "postgres" == dialect {
-			if result := conn.Exec(fmt.Sprintf("CREATE SCHEMA %s", name)); result.Error != nil {
-				return result.Error
-			}
-		} else {
-			log.Default.Warnf("Schema creation not supported by: %s", dialect)
+		if !link.supportsSchemas() {
+			log.Default.Warnf("Schema creation not supported by: %s", link.ds.dialect.name)
+			return nil
 		}
-		return nil
+		return link.ds.dialect.create(conn, name)
 	})
 }
 
 func (link *GormLink) Transactional(callback func(link BaseLink) error) error {
 	return link.withConn(func(conn *gorm.DB) error {
 		return conn.Transaction(func(tx *gorm.DB) error {
-			link := &GormLink{conn: tx, ds: link.ds}
+			link := &GormLink{conn: tx, ds: link.ds, tenant: link.tenant, system: link.system, active: true, principal: link.principal}
 			return callback(link)
 		})
 	})
@@ -116,14 +181,8 @@ func (link *GormLink) Find(dest interface{}, query Query) Result {
 
 	res := &Result{}
 
-	res.Error = link.withConn(func(conn *gorm.DB) error {
-		exec := conn.Offset(query.offset).Limit(query.limit).Order(query.sort)
-		if query.whereMap != nil {
-			exec = exec.Where(h.UnwrapMap(*query.whereMap))
-		} else if !h.IsEmpty(query.where) {
-			exec = exec.Where(query.where, query.args...)
-		}
-		out := exec.Find(dest)
+	res.Error = link.withReadConn(func(conn *gorm.DB) error {
+		out := query.apply(conn).Find(dest)
 		if out.Error != nil {
 			dest = nil
 			return out.Error
@@ -143,7 +202,7 @@ func (link *GormLink) Find(dest interface{}, query Query) Result {
 
 func (link *GormLink) Truncate(model interface{}) error {
 	return link.withConn(func(conn *gorm.DB) error {
-		return conn.Delete(model, "1=1").Error
+		return conn.Session(&gorm.Session{AllowGlobalUpdate: true}).Unscoped().Delete(model).Error
 	})
 }
 
@@ -152,10 +211,14 @@ func (link *GormLink) ExistsById(model interface{}, id string) (bool, error) {
 }
 
 func (link *GormLink) ExistsBy(model interface{}, where string, args ...interface{}) (bool, error) {
+	return link.Exists(model, *Q().Wheres(where, args...))
+}
+
+func (link *GormLink) Exists(model interface{}, query Query) (bool, error) {
 	var out = false
-	err := link.withConn(func(conn *gorm.DB) error {
+	err := link.withReadConn(func(conn *gorm.DB) error {
 		var count int64
-		if res := conn.Model(model).Where(where, args...).Count(&count); res.Error != nil {
+		if res := query.filter(conn).Model(model).Limit(1).Count(&count); res.Error != nil {
 			return res.Error
 		}
 		out = count > 0
@@ -168,20 +231,34 @@ func (link *GormLink) UseSchema(name string) error {
 	if !link.supportsSchemas() {
 		return nil
 	}
-	if res := link.conn.Exec(fmt.Sprintf("SET search_path to %s", name)); res.Error != nil {
-		return res.Error
+	return link.ds.dialect.use(link.conn, name)
+}
+
+// checkRawSql rejects the raw statements of the row isolated tenants, they
+// can't be filtered unless the RLS policies do it.
+func (link *GormLink) checkRawSql() error {
+	if link.ds.rowIsolation() && !link.ds.RowLevelSecurity && !link.system {
+		return errors.Errorf("[%s] raw sql is not isolated by tenant, enable RowLevelSecurity to use it", link.ds.Id)
 	}
 	return nil
 }
 
 func (link *GormLink) supportsSchemas() bool {
-	return link.conn.Dialector.Name() != "sqlite"
+	return link.ds.dialect.supportsTenancy() && !link.ds.rowIsolation()
+}
+
+func (link *GormLink) supportsSkipLocked() bool {
+	return link.ds.dialect.skipLocked
+}
+
+func (link *GormLink) dropSchema(name string) error {
+	return link.ds.dialect.drop(link.conn, name)
 }
 
 func (link *GormLink) createSchemas(names ...string) error {
 	for _, name := range names {
-		if res := link.conn.Exec(fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", name)); res.Error != nil {
-			return res.Error
+		if err := link.ds.dialect.create(link.conn, name); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -191,23 +268,43 @@ func (link *GormLink) createSchemas(names ...string) error {
 // ------------------------------------------------------------------------------------------------
 
 func (link *GormLink) withConn(cb func(tx *gorm.DB) error) error {
+	return link.withConnOn(link.conn, cb)
+}
+
+// withReadConn runs cb on a replica, if any.
+func (link *GormLink) withReadConn(cb func(tx *gorm.DB) error) error {
+	if len(link.replicas) == 0 {
+		return link.withConn(cb)
+	}
+	i := atomic.AddUint32(link.next, 1)
+	return link.withConnOn(link.replicas[int(i)%len(link.replicas)], cb)
+}
+
+func (link *GormLink) withConnOn(conn *gorm.DB, cb func(tx *gorm.DB) error) error {
 	var err error
-	if h.IsEmpty(link.tenant) {
-		err = cb(link.conn)
+	if link.principal != "" {
+		conn = withPrincipal(conn, link.principal)
+	}
+	if link.active {
+		err = cb(conn)
+	} else if h.IsEmpty(link.tenant) {
+		if link.ds.multitenant() && !link.system {
+			// fail closed, the default schema must not be used by mistake
+			err = errors.NewTechnicalError(ErrTenantRequiredCode, fmt.Sprintf("datasource %s requires a tenant", link.ds.Id))
+		} else {
+			err = cb(conn)
+		}
 	} else {
-		_, exists := link.conn.Get("tenant_active")
-		if exists {
-			err = cb(link.conn)
+		// system links (e.g. the outbox relay) reach the suspended tenants too
+		if !link.system {
+			if err = link.ds.checkTenant(link.tenant); err != nil {
+				return err
+			}
+		}
+		if link.ds.rowIsolation() {
+			err = link.withRowTenant(conn, cb)
 		} else {
-			err = link.conn.Transaction(func(tx *gorm.DB) error {
-				tx.Set("tenant_active", true)
-				if link.supportsSchemas() {
-					if res := tx.Exec(fmt.Sprintf("SET search_path to %s", link.tenant)); res.Error != nil {
-						return res.Error
-					}
-				}
-				return cb(tx)
-			})
+			err = link.withSchemaTenant(conn, cb)
 		}
 	}
 	link.ds.counterOperations.Record(err)
@@ -217,3 +314,28 @@ func (link *GormLink) withConn(cb func(tx *gorm.DB) error) error {
 	return err
 
 }
+
+func (link *GormLink) withSchemaTenant(conn *gorm.DB, cb func(tx *gorm.DB) error) error {
+	return conn.Transaction(func(tx *gorm.DB) error {
+		if link.supportsSchemas() {
+			if err := link.ds.dialect.use(tx, link.tenant); err != nil {
+				return err
+			}
+			defer link.ds.restoreSchema(tx)
+		}
+		return cb(tx)
+	})
+}
+
+func (link *GormLink) withRowTenant(conn *gorm.DB, cb func(tx *gorm.DB) error) error {
+	conn = withRowTenant(conn, link.tenant)
+	if !link.ds.RowLevelSecurity {
+		return cb(conn)
+	}
+	return conn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT set_config(?, ?, true)", rlsSetting, link.tenant).Error; err != nil {
+			return err
+		}
+		return cb(tx)
+	})
+}
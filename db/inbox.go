@@ -0,0 +1,129 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	sctx "github.com/soffa-io/soffa-core-go/context"
+	"github.com/soffa-io/soffa-core-go/h"
+	"github.com/soffa-io/soffa-core-go/log"
+	"gorm.io/gorm"
+)
+
+const inboxMigrationId = "soffa_inbox_0001"
+
+// InboxMessage records a message processed by a consumer.
+type InboxMessage struct {
+	Consumer    string    `gorm:"primaryKey;size:255"`
+	MessageId   string    `gorm:"primaryKey;size:64"`
+	ProcessedAt time.Time `gorm:"index"`
+}
+
+func (InboxMessage) TableName() string {
+	return "inbox_messages"
+}
+
+// InboxMigration creates the inbox table, it is added automatically to the
+// migrations of a DS with Inbox enabled.
+func InboxMigration() *gormigrate.Migration {
+	return &gormigrate.Migration{
+		ID: inboxMigrationId,
+		Migrate: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&InboxMessage{})
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&InboxMessage{})
+		},
+	}
+}
+
+type linkKey struct{}
+
+// LinkFrom returns the transaction opened for the message being handled (see
+// Inbox), or fallback outside of it, routed to the tenant of ctx. The audit
+// fields are filled with the principal of ctx.
+func LinkFrom(ctx context.Context, fallback *Link) *Link {
+	link := fallback
+	if tx, ok := ctx.Value(linkKey{}).(*Link); ok {
+		link = tx
+	} else if tenant := sctx.TenantFrom(ctx); !h.IsStrEmpty(tenant) {
+		link = fallback.Tenant(tenant)
+	}
+	if principal := sctx.PrincipalFrom(ctx); !h.IsStrEmpty(principal) {
+		link = link.Principal(principal)
+	}
+	return link
+}
+
+func WithLink(ctx context.Context, link *Link) context.Context {
+	return context.WithValue(ctx, linkKey{}, link)
+}
+
+// Inbox is the database backed broker.InboxStore. The handler runs in the
+// transaction that records the message, get it with LinkFrom. Entries older
+// than ttl are purged along the way.
+type Inbox struct {
+	link      *Link
+	ttl       time.Duration
+	mu        sync.Mutex
+	lastPurge time.Time
+}
+
+func NewInbox(link *Link, ttl time.Duration) *Inbox {
+	return &Inbox{link: link, ttl: ttl, lastPurge: time.Now()}
+}
+
+func (i *Inbox) Process(ctx context.Context, consumer string, messageId string, tenant string, fn func(ctx context.Context) error) (bool, error) {
+	link := i.link
+	if !h.IsStrEmpty(tenant) && link.ds.multitenant() {
+		link = link.Tenant(tenant)
+	}
+	i.purgeIfDue(link)
+	duplicate := false
+	err := link.base.Transactional(func(base BaseLink) error {
+		exists, err := base.ExistsBy(&InboxMessage{}, "consumer = ? AND message_id = ?", consumer, messageId)
+		if err != nil || exists {
+			duplicate = exists
+			return err
+		}
+		tx := &Link{ds: link.ds, base: base}
+		if err := fn(WithLink(ctx, tx)); err != nil {
+			return err
+		}
+		return base.Create(&InboxMessage{Consumer: consumer, MessageId: messageId, ProcessedAt: time.Now().UTC()})
+	})
+	return duplicate, err
+}
+
+// Purge deletes the entries processed before the given time.
+func (i *Inbox) Purge(link *Link, before time.Time) (int64, error) {
+	var count int64
+	err := link.base.(*GormLink).withConn(func(conn *gorm.DB) error {
+		res := conn.Where("processed_at < ?", before).Delete(&InboxMessage{})
+		count = res.RowsAffected
+		return res.Error
+	})
+	return count, err
+}
+
+func (i *Inbox) purgeIfDue(link *Link) {
+	if i.ttl <= 0 {
+		return
+	}
+	i.mu.Lock()
+	due := time.Since(i.lastPurge) > i.ttl/10
+	if due {
+		i.lastPurge = time.Now()
+	}
+	i.mu.Unlock()
+	if !due {
+		return
+	}
+	if count, err := i.Purge(link, time.Now().UTC().Add(-i.ttl)); err != nil {
+		log.Default.Wrap(err, "unable to purge inbox")
+	} else if count > 0 {
+		log.Default.Infof("%d inbox entries purged", count)
+	}
+}
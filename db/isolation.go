@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/soffa-io/soffa-core-go/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Isolation strategies of the tenants of a datasource. With IsolationSchema
+// (the default) each tenant has its schema or database, see the dialects. With
+// IsolationRow the tenants share the tables, the rows of the models embedding
+// TenantModel are filtered and stamped with the tenant of the link.
+const (
+	IsolationSchema = "schema"
+	IsolationRow    = "row"
+
+	TenantColumn = "tenant_id"
+
+	// rlsSetting holds the tenant of the transaction for the RLS policies
+	rlsSetting = "soffa.tenant_id"
+	rlsPolicy  = "soffa_tenant_isolation"
+)
+
+// TenantModel is embedded by the models owned by a tenant, on datasources with
+// IsolationRow.
+type TenantModel struct {
+	TenantId string `gorm:"size:63;not null;index" json:"-"`
+}
+
+type rowTenantKey struct{}
+
+func (ds *DS) rowIsolation() bool {
+	return ds.Isolation == IsolationRow
+}
+
+func (ds *DS) checkIsolation() {
+	switch ds.Isolation {
+	case "", IsolationSchema, IsolationRow:
+	default:
+		errors.RaiseNew("[%s] unknown isolation: %s", ds.Id, ds.Isolation)
+	}
+	if ds.RowLevelSecurity && (!ds.rowIsolation() || ds.dialect.name != "postgres") {
+		errors.RaiseNew("[%s] RowLevelSecurity requires postgres and IsolationRow", ds.Id)
+	}
+}
+
+// registerRowIsolation installs the callbacks that filter the queries and
+// stamp the inserts with the tenant of the link.
+func registerRowIsolation(conn *gorm.DB) {
+	cb := conn.Callback()
+	errors.Raise(
+		cb.Create().Before("gorm:create").Register("soffa:tenant_create", stampTenant),
+		cb.Query().Before("gorm:query").Register("soffa:tenant_query", filterTenant),
+		cb.Update().Before("gorm:update").Register("soffa:tenant_update", func(db *gorm.DB) {
+			stampTenant(db)
+			filterTenant(db)
+		}),
+		cb.Delete().Before("gorm:delete").Register("soffa:tenant_delete", filterTenant),
+		cb.Row().Before("gorm:row").Register("soffa:tenant_row", filterTenant),
+	)
+}
+
+func withRowTenant(conn *gorm.DB, tenant string) *gorm.DB {
+	return conn.WithContext(context.WithValue(conn.Statement.Context, rowTenantKey{}, tenant))
+}
+
+// tenantField returns the tenant of the statement and the field it is stored
+// in, if the model is owned by a tenant.
+func tenantField(db *gorm.DB) (string, *schema.Field) {
+	if db.Statement.Context == nil || db.Statement.Schema == nil {
+		return "", nil
+	}
+	tenant, ok := db.Statement.Context.Value(rowTenantKey{}).(string)
+	if !ok {
+		return "", nil
+	}
+	return tenant, db.Statement.Schema.LookUpField(TenantColumn)
+}
+
+func filterTenant(db *gorm.DB) {
+	tenant, field := tenantField(db)
+	if field == nil {
+		return
+	}
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: tenant},
+	}})
+}
+
+func stampTenant(db *gorm.DB) {
+	tenant, field := tenantField(db)
+	if field == nil {
+		return
+	}
+	rv := db.Statement.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			_ = db.AddError(field.Set(reflect.Indirect(rv.Index(i)), tenant))
+		}
+	case reflect.Struct:
+		_ = db.AddError(field.Set(rv, tenant))
+	}
+}
+
+// deleteTenantRows deletes the rows of the tenant from every table with a
+// tenant column.
+func (ds *DS) deleteTenantRows(tenant string) error {
+	conn := ds.system.base.(*GormLink).conn
+	var tables []string
+	if err := conn.Raw(ds.dialect.listTables).Scan(&tables).Error; err != nil {
+		return err
+	}
+	return conn.Transaction(func(tx *gorm.DB) error {
+		if ds.RowLevelSecurity {
+			// the policies hide the rows of the other tenants, the system link too
+			if err := tx.Exec("SELECT set_config(?, ?, true)", rlsSetting, tenant).Error; err != nil {
+				return err
+			}
+		}
+		for _, table := range tables {
+			if !tx.Migrator().HasColumn(table, TenantColumn) {
+				continue
+			}
+			statement := fmt.Sprintf("DELETE FROM %s WHERE %s = ?", tx.Statement.Quote(table), tx.Statement.Quote(TenantColumn))
+			if err := tx.Exec(statement, tenant).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// EnableRowLevelSecurity creates the postgres RLS policies that restrict the
+// tables of models to the rows of the tenant of the transaction, call it from
+// a migration of a datasource with RowLevelSecurity.
+func EnableRowLevelSecurity(tx *gorm.DB, models ...interface{}) error {
+	if tx.Dialector.Name() != "postgres" {
+		return errors.Errorf("row level security is not supported by %s", tx.Dialector.Name())
+	}
+	for _, model := range models {
+		for _, statement := range rowLevelSecurityStatements(tx, model) {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func rowLevelSecurityStatements(tx *gorm.DB, model interface{}) []string {
+	stmt := &gorm.Statement{DB: tx}
+	errors.Raise(stmt.Parse(model))
+	table := stmt.Quote(stmt.Schema.Table)
+	condition := fmt.Sprintf("%s = current_setting('%s', true)", stmt.Quote(TenantColumn), rlsSetting)
+	return []string{
+		fmt.Sprintf("ALTER TABLE %s ENABLE ROW LEVEL SECURITY", table),
+		fmt.Sprintf("ALTER TABLE %s FORCE ROW LEVEL SECURITY", table),
+		fmt.Sprintf("DROP POLICY IF EXISTS %s ON %s", rlsPolicy, table),
+		fmt.Sprintf("CREATE POLICY %s ON %s USING (%s) WITH CHECK (%s)", rlsPolicy, table, condition, condition),
+	}
+}
@@ -1,6 +1,8 @@
 package db
 
 import (
+	"context"
+
 	"github.com/soffa-io/soffa-core-go/errors"
 	"github.com/soffa-io/soffa-core-go/h"
 )
@@ -11,9 +13,15 @@ type BaseLink interface {
 	MigrateTenant(schema string)
 	Migrate()
 	WithTenant(tenant string) BaseLink
+	WithPrincipal(principal string) BaseLink
+	Primary() BaseLink
 	Ping() error
+	PingContext(ctx context.Context) error
+	Close() error
 	Create(model interface{}) error
 	Save(model interface{}) error
+	Update(model interface{}) (int64, error)
+	Delete(model interface{}, query Query) (int64, error)
 	Exec(command string) error
 	Raw(result interface{}, query string, values ...interface{}) error
 	Pluck(table interface{}, column string, dest interface{}) error
@@ -24,8 +32,10 @@ type BaseLink interface {
 	Truncate(model interface{}) error
 	ExistsById(model interface{}, id string) (bool, error)
 	ExistsBy(model interface{}, where string, args ...interface{}) (bool, error)
+	Exists(model interface{}, query Query) (bool, error)
 	UseSchema(name string) error
 	supportsSchemas() bool
+	supportsSkipLocked() bool
 	createSchemas(schemas ...string) error
 }
 
@@ -46,6 +56,15 @@ func (l *Link) Ping() error {
 	return l.base.Ping()
 }
 
+// PingContext is Ping, cancelled with ctx.
+func (l *Link) PingContext(ctx context.Context) error {
+	return l.base.PingContext(ctx)
+}
+
+func (l *Link) Close() error {
+	return l.base.Close()
+}
+
 func (l *Link) Create(model interface{}) {
 	errors.Raise(l.base.Create(model))
 }
@@ -72,18 +91,16 @@ func (l *Link) CreateSchema(name string) {
 	errors.Raise(l.base.CreateSchema(name))
 }
 
-func (l *Link) Find(dest interface{}, query *Query)  {
+func (l *Link) Find(dest interface{}, query *Query) {
 	res := l.base.Find(dest, *query)
 	errors.Raise(res.Error)
 }
 
-
-func (l *Link) Raw(result interface{}, query string, values ...interface{})  {
+func (l *Link) Raw(result interface{}, query string, values ...interface{}) {
 	err := l.base.Raw(result, query, values...)
 	errors.Raise(err)
 }
 
-
 func (l *Link) First(dest interface{}, query *Query) bool {
 	query.Limit(1)
 	res := l.base.Find(dest, *query)
@@ -111,6 +128,14 @@ func (l *Link) ExistsBy(model interface{}, where string, args ...interface{}) bo
 	return res
 }
 
+func (l *Link) Exists(model interface{}, query *Query) bool {
+	res, err := l.base.Exists(model, *query)
+	errors.Raise(err)
+	return res
+}
+
+// UseSchema selects the schema of a transaction (see Transactional), postgres
+// resets it when the transaction ends.
 func (l *Link) UseSchema(name string) {
 	errors.Raise(l.base.UseSchema(name))
 }
@@ -118,6 +143,10 @@ func (l *Link) supportsSchemas() bool {
 	return l.base.supportsSchemas()
 }
 
+func (l *Link) supportsSkipLocked() bool {
+	return l.base.supportsSkipLocked()
+}
+
 func (l *Link) createSchemas(schemas ...string) {
 	errors.Raise(l.base.createSchemas(schemas...))
 }
@@ -129,6 +158,42 @@ func (l *Link) Transactional(callback func(link *Link)) {
 	}))
 }
 
+// PublishEvent writes an event to the outbox of the datasource, use it inside
+// Transactional so that the event is only published if the transaction commits.
+// Payloads are JSON encoded unless given as raw bytes.
+func (l *Link) PublishEvent(subject string, payload interface{}, headers ...map[string]string) string {
+	if base, ok := l.base.(*GormLink); ok && l.ds.rowIsolation() && !h.IsStrEmpty(base.tenant) {
+		// the relay publishes the events of all tenants, see OutboxRelay.Flush
+		headers = append([]map[string]string{{"X-Tenant-Id": base.tenant}}, headers...)
+	}
+	event, err := newOutboxEvent(subject, payload, headers)
+	errors.Raise(err)
+	errors.Raise(l.base.Create(event))
+	return event.Id
+}
+
+// Primary returns a link that reads from the primary even when the datasource
+// has replicas, use it to read your own writes.
+func (l *Link) Primary() *Link {
+	return &Link{ds: l.ds, base: l.base.Primary()}
+}
+
 func (l *Link) Tenant(tenant string) *Link {
 	return &Link{ds: l.ds, base: l.base.WithTenant(tenant)}
 }
+
+// Principal returns a link that fills the audit fields of the models with
+// principal, see AuditModel.
+func (l *Link) Principal(principal string) *Link {
+	return &Link{ds: l.ds, base: l.base.WithPrincipal(principal)}
+}
+
+// WithContext returns the link to use while handling ctx, see LinkFrom.
+func (l *Link) WithContext(ctx context.Context) *Link {
+	return LinkFrom(ctx, l)
+}
+
+// HasOutbox reports whether events published with PublishEvent are relayed.
+func (l *Link) HasOutbox() bool {
+	return l.ds.Outbox
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"github.com/soffa-io/soffa-core-go/errors"
 	"github.com/soffa-io/soffa-core-go/h"
 	"github.com/soffa-io/soffa-core-go/log"
@@ -8,9 +9,10 @@ import (
 )
 
 type Manager struct {
-	ds          map[string]*DS
-	migrated    bool
-	serviceName string
+	ds             map[string]*DS
+	migrated       bool
+	serviceName    string
+	tenantListener func(event TenantEvent)
 }
 
 func NewManager(serviceName string) *Manager {
@@ -35,6 +37,7 @@ func (m *Manager) Add(ds DS) *Link {
 		log.Default.Fatal("Database url cannot be empty")
 	}
 	ds.serviceName = m.serviceName
+	ds.tenantListener = m.tenantListener
 	ds.bootstrap()
 	m.ds[ds.Id] = &ds
 	return ds.link
@@ -81,17 +84,37 @@ func (m *Manager) GetLinkN(id string) *Link {
 }
 
 func (m *Manager) Ping() error {
+	return m.PingContext(context.Background())
+}
+
+// PingContext pings every datasource, it gives up when ctx is done.
+func (m *Manager) PingContext(ctx context.Context) error {
 	if m.ds == nil || len(m.ds) == 0 {
 		return nil
 	}
 	for _, ds := range m.ds {
-		if err := ds.ping(); err != nil {
+		if err := ds.ping(ctx); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// Close releases the connection pool of every datasource. All datasources are
+// closed even if one of them fails; the first error is returned.
+func (m *Manager) Close() error {
+	var first error
+	for _, ds := range m.ds {
+		if err := ds.close(); err != nil {
+			log.Default.Wrapf(err, "[%s] unable to close datasource", ds.Id)
+			first = errors.AnyError(first, err)
+		} else {
+			log.Default.Infof("[%s] datasource closed", ds.Id)
+		}
+	}
+	return first
+}
+
 func (m *Manager) IsEmpty() bool {
 	return m.ds == nil || len(m.ds) == 0
 }
@@ -0,0 +1,282 @@
+package db
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/soffa-io/soffa-core-go/h"
+	"github.com/soffa-io/soffa-core-go/log"
+	"gorm.io/gorm"
+)
+
+// MigrationStatus tells whether a migration was applied on a datasource (and
+// tenant). Unknown migrations were applied but are not defined anymore.
+type MigrationStatus struct {
+	Datasource string
+	Tenant     string
+	Id         string
+	Applied    bool
+	Unknown    bool
+}
+
+// PendingMigration is a migration that Migrate would apply, Sql is empty for
+// the Go migrations.
+type PendingMigration struct {
+	Datasource string
+	Tenant     string
+	Id         string
+	Sql        string
+}
+
+type sqlMigration struct {
+	id   string
+	up   string
+	down string
+}
+
+var sqlMigrationFile = regexp.MustCompile(`^(.+)\.(up|down)\.sql$`)
+
+// migration runs the statements of the file as a whole, mysql requires
+// multiStatements=true in the url for files with several statements.
+func (m *sqlMigration) migration() *gormigrate.Migration {
+	migration := &gormigrate.Migration{
+		ID: m.id,
+		Migrate: func(tx *gorm.DB) error {
+			return tx.Exec(m.up).Error
+		},
+	}
+	if !h.IsStrEmpty(m.down) {
+		migration.Rollback = func(tx *gorm.DB) error {
+			return tx.Exec(m.down).Error
+		}
+	}
+	return migration
+}
+
+// sqlMigrations loads the .sql files of MigrationFiles, sorted by id.
+func (ds *DS) sqlMigrations() []*sqlMigration {
+	index := map[string]*sqlMigration{}
+	err := fs.WalkDir(ds.MigrationFiles, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		parts := sqlMigrationFile.FindStringSubmatch(path.Base(name))
+		if parts == nil {
+			return nil
+		}
+		data, err := fs.ReadFile(ds.MigrationFiles, name)
+		if err != nil {
+			return err
+		}
+		m, ok := index[parts[1]]
+		if !ok {
+			m = &sqlMigration{id: parts[1]}
+			index[m.id] = m
+		}
+		if parts[2] == "up" {
+			m.up = string(data)
+		} else {
+			m.down = string(data)
+		}
+		return nil
+	})
+	errors.Raisef(err, "[%s] unable to load the migration files", ds.Id)
+	migrations := make([]*sqlMigration, 0, len(index))
+	for _, m := range index {
+		if h.IsStrEmpty(m.up) {
+			errors.RaiseNew("[%s] migration %s has no up file", ds.Id, m.id)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].id < migrations[j].id
+	})
+	return migrations
+}
+
+func (ds *DS) status() []MigrationStatus {
+	var result []MigrationStatus
+	migrations := ds.migrations()
+	for _, schema := range ds.schemas() {
+		applied := ds.appliedMigrations(schema)
+		for _, m := range migrations {
+			result = append(result, MigrationStatus{Datasource: ds.Id, Tenant: schema, Id: m.ID, Applied: applied[m.ID]})
+			delete(applied, m.ID)
+		}
+		var unknown []string
+		for id := range applied {
+			unknown = append(unknown, id)
+		}
+		sort.Strings(unknown)
+		for _, id := range unknown {
+			result = append(result, MigrationStatus{Datasource: ds.Id, Tenant: schema, Id: id, Applied: true, Unknown: true})
+		}
+	}
+	return result
+}
+
+// appliedMigrations reads the migrations applied on schema without creating
+// anything, none are applied when the schema doesn't exist yet.
+func (ds *DS) appliedMigrations(schema string) map[string]bool {
+	applied := map[string]bool{}
+	base := ds.system.base.(*GormLink)
+	tenant := !h.IsEmpty(schema) && base.supportsSchemas()
+	if tenant {
+		exists, err := ds.dialect.exists(base.conn, schema)
+		errors.Raisef(err, "[%s] unable to check schema %s", ds.Id, schema)
+		if !exists {
+			return applied
+		}
+	}
+	options := ds.migrationOptions()
+	ds.system.Transactional(func(tx *Link) {
+		conn := tx.base.(*GormLink).conn
+		if tenant {
+			tx.UseSchema(schema)
+			defer ds.restoreSchema(conn)
+		}
+		if !conn.Migrator().HasTable(options.TableName) {
+			return
+		}
+		var ids []string
+		errors.Raise(conn.Table(options.TableName).Pluck(options.IDColumnName, &ids).Error)
+		for _, id := range ids {
+			applied[id] = true
+		}
+	})
+	return applied
+}
+
+func (ds *DS) dryRun() []PendingMigration {
+	var result []PendingMigration
+	files := map[string]string{}
+	if ds.MigrationFiles != nil {
+		for _, m := range ds.sqlMigrations() {
+			files[m.id] = m.up
+		}
+	}
+	migrations := ds.migrations()
+	for _, schema := range ds.schemas() {
+		applied := ds.appliedMigrations(schema)
+		for _, m := range migrations {
+			if !applied[m.ID] {
+				result = append(result, PendingMigration{Datasource: ds.Id, Tenant: schema, Id: m.ID, Sql: files[m.ID]})
+			}
+		}
+	}
+	return result
+}
+
+// rollback undoes the last migration, or the ones applied after the migration
+// to when it is set. The built-in migrations are left alone, their tables hold
+// the pending events and the processed messages.
+func (ds *DS) rollback(to string) {
+	migrations := ds.userMigrations()
+	for _, schema := range ds.schemas() {
+		ds.withMigrator(migrations, schema, func(m *gormigrate.Gormigrate) {
+			var err error
+			if h.IsStrEmpty(to) {
+				err = m.RollbackLast()
+			} else {
+				err = m.RollbackTo(to)
+			}
+			if err == gormigrate.ErrNoRunMigration {
+				log.Default.Infof("[%s] no migration to roll back %s", ds.Id, schema)
+				return
+			}
+			ds.counterOperations.Record(err)
+			ds.counterMigrations.Record(err)
+			errors.Raisef(err, "[%s] rollback failed %s", ds.Id, schema)
+			log.Default.Infof("[%s] migrations rolled back %s", ds.Id, schema)
+		})
+	}
+}
+
+func (ds *DS) defines(id string) bool {
+	for _, m := range ds.userMigrations() {
+		if m.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Status returns the state of the migrations of every datasource and tenant.
+func (m *Manager) Status() []MigrationStatus {
+	var result []MigrationStatus
+	for _, ds := range m.sorted() {
+		result = append(result, ds.status()...)
+	}
+	return result
+}
+
+// DryRun returns the migrations Migrate would apply, without applying them.
+func (m *Manager) DryRun() []PendingMigration {
+	var result []PendingMigration
+	for _, ds := range m.sorted() {
+		result = append(result, ds.dryRun()...)
+	}
+	return result
+}
+
+// Rollback undoes the last migration of the datasource dsId (all of them when
+// empty) on every tenant. When to is set, the migrations applied after it are
+// rolled back, on the datasources that define it.
+func (m *Manager) Rollback(dsId string, to string) {
+	if !h.IsStrEmpty(dsId) {
+		ds, ok := m.ds[dsId]
+		if !ok {
+			errors.RaiseNew("invalid datasource id: %s", dsId)
+		}
+		ds.rollback(to)
+		return
+	}
+	found := false
+	for _, ds := range m.sorted() {
+		if h.IsStrEmpty(to) || ds.defines(to) {
+			found = true
+			ds.rollback(to)
+		}
+	}
+	if !found {
+		errors.RaiseNew("unknown migration: %s", to)
+	}
+}
+
+func (m *Manager) sorted() []*DS {
+	items := make([]*DS, 0, len(m.ds))
+	for _, ds := range m.ds {
+		items = append(items, ds)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Id < items[j].Id
+	})
+	return items
+}
+
+var migrationName = regexp.MustCompile("[^a-z0-9]+")
+
+// CreateMigrationFiles creates empty up and down files for the migration name
+// in dir, their id is prefixed with the current (UTC) time.
+func CreateMigrationFiles(dir string, name string) (up string, down string) {
+	name = strings.Trim(migrationName.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		errors.RaiseValidationError("migration name is required")
+	}
+	id := fmt.Sprintf("%s_%s", time.Now().UTC().Format("20060102150405"), name)
+	errors.Raise(os.MkdirAll(dir, 0755))
+	up = filepath.Join(dir, id+".up.sql")
+	down = filepath.Join(dir, id+".down.sql")
+	errors.Raise(os.WriteFile(up, []byte(fmt.Sprintf("-- %s\n", id)), 0644))
+	errors.Raise(os.WriteFile(down, []byte(fmt.Sprintf("-- rollback of %s\n", id)), 0644))
+	return up, down
+}
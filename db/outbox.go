@@ -0,0 +1,274 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"github.com/soffa-io/soffa-core-go/counters"
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/soffa-io/soffa-core-go/h"
+	"github.com/soffa-io/soffa-core-go/log"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+
+	outboxMigrationId = "soffa_outbox_0001"
+)
+
+var (
+	OutboxRelayCounter  = counters.NewCounter("x_sys_outbox_relay", "Will track outbox events published", true)
+	OutboxFailedCounter = counters.NewCounter("x_sys_outbox_failed", "Will track outbox events given up after all attempts", true)
+)
+
+// OutboxEvent is an event written in the same transaction as the business data,
+// the relay publishes it afterwards so that it is never lost.
+type OutboxEvent struct {
+	Id            string `gorm:"primaryKey;size:64"`
+	Subject       string `gorm:"size:255;not null"`
+	Payload       []byte
+	ContentType   string `gorm:"size:64"`
+	Headers       string
+	Status        string `gorm:"size:16;not null;index"`
+	Attempts      int
+	LastError     string
+	CreatedAt     time.Time
+	NextAttemptAt time.Time `gorm:"index"`
+	SentAt        *time.Time
+	Tenant        string `gorm:"-"`
+}
+
+func (OutboxEvent) TableName() string {
+	return "outbox_events"
+}
+
+func (e OutboxEvent) GetHeaders() map[string]string {
+	headers := map[string]string{}
+	if !h.IsStrEmpty(e.Headers) {
+		_ = json.Unmarshal([]byte(e.Headers), &headers)
+	}
+	return headers
+}
+
+// OutboxMigration creates the outbox table, it is added automatically to the
+// migrations of a DS with Outbox enabled.
+func OutboxMigration() *gormigrate.Migration {
+	return &gormigrate.Migration{
+		ID: outboxMigrationId,
+		Migrate: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&OutboxEvent{})
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&OutboxEvent{})
+		},
+	}
+}
+
+func newOutboxEvent(subject string, payload interface{}, headers []map[string]string) (*OutboxEvent, error) {
+	event := &OutboxEvent{
+		Id:            h.NewUniqueId(),
+		Subject:       subject,
+		Status:        OutboxPending,
+		CreatedAt:     time.Now().UTC(),
+		NextAttemptAt: time.Now().UTC(),
+	}
+	if raw, ok := payload.([]byte); ok {
+		event.Payload = raw
+	} else if !h.IsNil(payload) {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to encode outbox event %s", subject)
+		}
+		event.Payload = data
+		event.ContentType = "application/json"
+	}
+	all := map[string]string{}
+	for _, hs := range headers {
+		for k, v := range hs {
+			all[k] = v
+		}
+	}
+	if len(all) > 0 {
+		data, _ := json.Marshal(all)
+		event.Headers = string(data)
+	}
+	return event, nil
+}
+
+// OutboxPublisher sends an outbox event to the broker.
+type OutboxPublisher = func(event OutboxEvent) error
+
+type OutboxConfig struct {
+	Interval    time.Duration
+	BatchSize   int
+	MaxAttempts int
+	MaxBackoff  time.Duration
+}
+
+func DefaultOutboxConfig() OutboxConfig {
+	return OutboxConfig{
+		Interval:    time.Second,
+		BatchSize:   100,
+		MaxAttempts: 10,
+		MaxBackoff:  5 * time.Minute,
+	}
+}
+
+// OutboxRelay polls the outbox table of every datasource (and tenant schema)
+// with Outbox enabled and publishes the pending events. A failed publication is
+// retried with an exponential backoff until MaxAttempts is reached.
+type OutboxRelay struct {
+	manager *Manager
+	publish OutboxPublisher
+	config  OutboxConfig
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func (m *Manager) HasOutbox() bool {
+	for _, ds := range m.ds {
+		if ds.Outbox {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) StartOutboxRelay(publish OutboxPublisher, config OutboxConfig) *OutboxRelay {
+	relay := &OutboxRelay{
+		manager: m,
+		publish: publish,
+		config:  config,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go relay.run()
+	log.Default.Infof("outbox relay started (interval: %s)", config.Interval)
+	return relay
+}
+
+func (r *OutboxRelay) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.Flush()
+		}
+	}
+}
+
+// Stop waits for the current batch to be published, or for ctx to expire.
+func (r *OutboxRelay) Stop(ctx context.Context) error {
+	close(r.stop)
+	select {
+	case <-r.done:
+		log.Default.Info("outbox relay stopped")
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "outbox relay did not stop in time")
+	}
+}
+
+// Flush publishes the pending events once and returns how many were sent.
+func (r *OutboxRelay) Flush() int {
+	sent := 0
+	for _, ds := range r.manager.ds {
+		if !ds.Outbox {
+			continue
+		}
+		if !ds.system.supportsSchemas() {
+			// the events of all tenants share the table
+			sent += r.flush(ds, "")
+			continue
+		}
+		for _, tenant := range ds.schemas() {
+			sent += r.flush(ds, tenant)
+		}
+	}
+	return sent
+}
+
+func (r *OutboxRelay) flush(ds *DS, tenant string) (sent int) {
+	defer func() {
+		if re := recover(); re != nil {
+			log.Default.With("tenant", tenant).Errorf("[%s] outbox relay failed -- %v", ds.Id, re)
+		}
+	}()
+	link := ds.system
+	if !h.IsStrEmpty(tenant) {
+		link = link.Tenant(tenant)
+	}
+	link.Transactional(func(tx *Link) {
+		conn := tx.base.(*GormLink).conn
+		now := time.Now().UTC()
+		query := conn.Where("status = ? AND next_attempt_at <= ?", OutboxPending, now).
+			Order("created_at").Limit(r.config.BatchSize)
+		if tx.supportsSkipLocked() {
+			// other replicas skip the rows being published by this one
+			query = query.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+		}
+		var events []OutboxEvent
+		errors.Raise(query.Find(&events).Error)
+		for _, event := range events {
+			event.Tenant = tenant
+			if r.send(&event) {
+				sent++
+			}
+			errors.Raise(conn.Save(&event).Error)
+		}
+	})
+	return sent
+}
+
+func (r *OutboxRelay) send(event *OutboxEvent) bool {
+	err := r.safePublish(*event)
+	OutboxRelayCounter.Record(err)
+	event.Attempts++
+	if err == nil {
+		now := time.Now().UTC()
+		event.Status = OutboxSent
+		event.SentAt = &now
+		event.LastError = ""
+		return true
+	}
+	event.LastError = err.Error()
+	if event.Attempts >= r.config.MaxAttempts {
+		event.Status = OutboxFailed
+		OutboxFailedCounter.Inc()
+		log.Default.Errorf("outbox event %s (%s) failed after %d attempts -- %s", event.Id, event.Subject, event.Attempts, err)
+		return false
+	}
+	event.NextAttemptAt = time.Now().UTC().Add(r.backoff(event.Attempts))
+	log.Default.Warnf("outbox event %s (%s) not published, attempt %d -- %s", event.Id, event.Subject, event.Attempts, err)
+	return false
+}
+
+func (r *OutboxRelay) safePublish(event OutboxEvent) (err error) {
+	defer func() {
+		if re := recover(); re != nil {
+			err = errors.Errorf("%v", re)
+		}
+	}()
+	return r.publish(event)
+}
+
+func (r *OutboxRelay) backoff(attempts int) time.Duration {
+	delay := r.config.Interval
+	for i := 1; i < attempts && delay < r.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if r.config.MaxBackoff > 0 && delay > r.config.MaxBackoff {
+		delay = r.config.MaxBackoff
+	}
+	return delay
+}
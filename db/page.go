@@ -0,0 +1,182 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/soffa-io/soffa-core-go/errors"
+)
+
+// Page is a page of results with the total count of rows matching the query.
+type Page[T any] struct {
+	Items   []T   `json:"items"`
+	Total   int64 `json:"total"`
+	Page    int   `json:"page"`
+	Size    int   `json:"size"`
+	HasNext bool  `json:"hasNext"`
+}
+
+func (p Page[T]) Pagination() (page int, size int, total int64) {
+	return p.Page, p.Size, p.Total
+}
+
+// CursorPage is a page of results fetched with keyset pagination, Next is the
+// (opaque) cursor of the following page.
+type CursorPage[T any] struct {
+	Items   []T    `json:"items"`
+	Next    string `json:"next,omitempty"`
+	HasNext bool   `json:"hasNext"`
+}
+
+func (p CursorPage[T]) NextCursor() string {
+	return p.Next
+}
+
+// FindPage runs query for the page-th page (starting at 0) and counts the rows
+// matching its conditions.
+func FindPage[T any](link *Link, query *Query, page int, size int) Page[T] {
+	return newPage(query, page, size, func(q *Query) []T {
+		items := []T{}
+		link.Find(&items, q)
+		return items
+	}, func(q *Query) int64 {
+		var model T
+		return link.Count(&model, q)
+	})
+}
+
+// FindCursor runs query for the page following cursor (empty for the first
+// one). Rows are ordered by the query sort columns then by id, the sort columns
+// must not be nullable.
+func FindCursor[T any](link *Link, query *Query, cursor string, size int) CursorPage[T] {
+	return newCursorPage(query, cursor, size, func(q *Query) []T {
+		items := []T{}
+		link.Find(&items, q)
+		return items
+	})
+}
+
+func newPage[T any](query *Query, page int, size int, find func(q *Query) []T, count func(q *Query) int64) Page[T] {
+	checkPageSize(size)
+	q := *orAll(query)
+	items := find(q.Page(page, size))
+	total := count(orAll(query))
+	return Page[T]{
+		Items:   items,
+		Total:   total,
+		Page:    page,
+		Size:    size,
+		HasNext: int64((page+1)*size) < total,
+	}
+}
+
+func newCursorPage[T any](query *Query, cursor string, size int, find func(q *Query) []T) CursorPage[T] {
+	checkPageSize(size)
+	q := *orAll(query)
+	orders := keysetOrders(q.orders)
+	q.orders = orders
+	if cursor != "" {
+		var model T
+		q.conds = append(append([]Cond{}, q.conds...), after(orders, decodeCursor(model, orders, cursor)))
+	}
+	items := find(q.Offset(0).Limit(size + 1))
+	page := CursorPage[T]{Items: items}
+	if len(items) > size {
+		page.Items = items[:size]
+		page.HasNext = true
+		page.Next = encodeCursor(page.Items[size-1], orders)
+	}
+	return page
+}
+
+func checkPageSize(size int) {
+	if size <= 0 {
+		errors.RaiseValidationError(fmt.Sprintf("page size must be positive, got %d", size))
+	}
+}
+
+// keysetOrders makes the order total by adding the id.
+func keysetOrders(orders []order) []order {
+	for _, o := range orders {
+		if o.column == "id" {
+			return orders
+		}
+	}
+	return append(append([]order{}, orders...), order{column: "id"})
+}
+
+// after selects the rows following values: (a > va) OR (a = va AND b > vb) ...
+func after(orders []order, values []interface{}) Cond {
+	var conds []Cond
+	for i, o := range orders {
+		var group []Cond
+		for j := 0; j < i; j++ {
+			group = append(group, Eq(orders[j].column, values[j]))
+		}
+		if o.desc {
+			group = append(group, Lt(o.column, values[i]))
+		} else {
+			group = append(group, Gt(o.column, values[i]))
+		}
+		conds = append(conds, And(group...))
+	}
+	return Or(conds...)
+}
+
+func encodeCursor(model interface{}, orders []order) string {
+	values := make([]interface{}, len(orders))
+	for i, o := range orders {
+		value, ok := field(model, columnName(o.column))
+		if !ok {
+			errors.RaiseNew("%T has no column %s", model, o.column)
+		}
+		if value.IsValid() {
+			values[i] = value.Interface()
+		}
+	}
+	data, err := json.Marshal(values)
+	errors.Raise(err)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor decodes the values of cursor, typed like the fields of model.
+func decodeCursor(model interface{}, orders []order, cursor string) []interface{} {
+	invalid := func() {
+		errors.RaiseValidationError("invalid cursor")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		invalid()
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil || len(raw) != len(orders) {
+		invalid()
+	}
+	t := reflect.TypeOf(model)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	values := make([]interface{}, len(orders))
+	for i, o := range orders {
+		f, ok := columnField(t, columnName(o.column))
+		if !ok {
+			invalid()
+		}
+		dest := reflect.New(f.Type)
+		if err := json.Unmarshal(raw[i], dest.Interface()); err != nil {
+			invalid()
+		}
+		values[i] = dest.Elem().Interface()
+	}
+	return values
+}
+
+func columnName(column string) string {
+	if i := strings.LastIndex(column, "."); i >= 0 {
+		return column[i+1:]
+	}
+	return column
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/soffa-io/soffa-core-go/counters"
+	"github.com/soffa-io/soffa-core-go/errors"
+	"gorm.io/gorm"
+)
+
+// Pool configures the connections of a datasource (and of its replicas), zero
+// values keep the database/sql defaults.
+type Pool struct {
+	MaxOpen     int
+	MaxIdle     int
+	MaxLifetime time.Duration
+	MaxIdleTime time.Duration
+}
+
+var (
+	PoolOpenGauge    = counters.NewSampled("x_sys_db_pool_open", "Will track open database connections", "service", "ds", "target")
+	PoolInUseGauge   = counters.NewSampled("x_sys_db_pool_in_use", "Will track database connections in use", "service", "ds", "target")
+	PoolIdleGauge    = counters.NewSampled("x_sys_db_pool_idle", "Will track idle database connections", "service", "ds", "target")
+	PoolMaxOpenGauge = counters.NewSampled("x_sys_db_pool_max_open", "Will track the maximum of open database connections", "service", "ds", "target")
+	PoolWaitGauge    = counters.NewSampled("x_sys_db_pool_wait_count", "Will track the connections waited for", "service", "ds", "target")
+)
+
+func (p Pool) apply(conn *gorm.DB) {
+	db, err := conn.DB()
+	errors.Raise(err)
+	if p.MaxOpen > 0 {
+		db.SetMaxOpenConns(p.MaxOpen)
+	}
+	if p.MaxIdle > 0 {
+		db.SetMaxIdleConns(p.MaxIdle)
+	}
+	if p.MaxLifetime > 0 {
+		db.SetConnMaxLifetime(p.MaxLifetime)
+	}
+	if p.MaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(p.MaxIdleTime)
+	}
+}
+
+// watchPool exports the pool statistics of the primary and of each replica.
+func (ds *DS) watchPool(primary *gorm.DB, replicas []*gorm.DB) {
+	ds.watchConn(primary, "primary")
+	for i, replica := range replicas {
+		ds.watchConn(replica, fmt.Sprintf("replica-%d", i))
+	}
+}
+
+func (ds *DS) watchConn(conn *gorm.DB, target string) {
+	db, err := conn.DB()
+	errors.Raise(err)
+	stat := func(fn func(stats sql.DBStats) int64) func() float64 {
+		return func() float64 {
+			return float64(fn(db.Stats()))
+		}
+	}
+	labels := []string{ds.serviceName, ds.Id, target}
+	PoolOpenGauge.Watch(stat(func(s sql.DBStats) int64 { return int64(s.OpenConnections) }), labels...)
+	PoolInUseGauge.Watch(stat(func(s sql.DBStats) int64 { return int64(s.InUse) }), labels...)
+	PoolIdleGauge.Watch(stat(func(s sql.DBStats) int64 { return int64(s.Idle) }), labels...)
+	PoolMaxOpenGauge.Watch(stat(func(s sql.DBStats) int64 { return int64(s.MaxOpenConnections) }), labels...)
+	PoolWaitGauge.Watch(stat(func(s sql.DBStats) int64 { return s.WaitCount }), labels...)
+}
@@ -1,15 +1,152 @@
 package db
 
-import "github.com/soffa-io/soffa-core-go/h"
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/soffa-io/soffa-core-go/h"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const ErrInvalidColumnCode = "FCOL"
+
+const (
+	opEq      = "eq"
+	opNe      = "ne"
+	opGt      = "gt"
+	opGte     = "gte"
+	opLt      = "lt"
+	opLte     = "lte"
+	opIn      = "in"
+	opLike    = "like"
+	opILike   = "ilike"
+	opBetween = "between"
+	opIsNull  = "null"
+	opNotNull = "notnull"
+	opAnd     = "and"
+	opOr      = "or"
+	opRaw     = "raw"
+)
+
+// columnPattern accepts plain or quoted ("name", `name`) identifiers, qualified
+// with their table or schema.
+var columnPattern = regexp.MustCompile("^" + identifier + `(\.` + identifier + ")*$")
+
+const identifier = "([a-zA-Z_][a-zA-Z0-9_]*|\"[^\".]+\"|`[^`.]+`)"
+
+// Cond is a query condition, build them with Eq, Gt, In, ... and group them
+// with And / Or.
+type Cond struct {
+	op     string
+	column string
+	values []interface{}
+	conds  []Cond
+}
+
+func newCond(op string, column string, values ...interface{}) Cond {
+	return Cond{op: op, column: checkColumn(column), values: values}
+}
+
+func Eq(column string, value interface{}) Cond         { return newCond(opEq, column, value) }
+func Ne(column string, value interface{}) Cond         { return newCond(opNe, column, value) }
+func Gt(column string, value interface{}) Cond         { return newCond(opGt, column, value) }
+func Gte(column string, value interface{}) Cond        { return newCond(opGte, column, value) }
+func Lt(column string, value interface{}) Cond         { return newCond(opLt, column, value) }
+func Lte(column string, value interface{}) Cond        { return newCond(opLte, column, value) }
+func In(column string, values ...interface{}) Cond     { return newCond(opIn, column, values...) }
+func Like(column string, pattern string) Cond          { return newCond(opLike, column, pattern) }
+func ILike(column string, pattern string) Cond         { return newCond(opILike, column, pattern) }
+func Between(column string, from, to interface{}) Cond { return newCond(opBetween, column, from, to) }
+func IsNull(column string) Cond                        { return newCond(opIsNull, column) }
+func NotNull(column string) Cond                       { return newCond(opNotNull, column) }
+
+func And(conds ...Cond) Cond {
+	if len(conds) == 1 {
+		return conds[0]
+	}
+	return Cond{op: opAnd, conds: conds}
+}
+
+func Or(conds ...Cond) Cond {
+	if len(conds) == 1 {
+		return conds[0]
+	}
+	return Cond{op: opOr, conds: conds}
+}
+
+// Raw is a plain SQL condition, it is not supported by the memory repository.
+func Raw(sql string, args ...interface{}) Cond {
+	return Cond{op: opRaw, column: sql, values: args}
+}
+
+// expression renders the condition for dialect.
+func (c Cond) expression(dialect string) clause.Expression {
+	col := clause.Column{Name: c.column}
+	switch c.op {
+	case opEq:
+		return clause.Eq{Column: col, Value: c.values[0]}
+	case opNe:
+		return clause.Neq{Column: col, Value: c.values[0]}
+	case opGt:
+		return clause.Gt{Column: col, Value: c.values[0]}
+	case opGte:
+		return clause.Gte{Column: col, Value: c.values[0]}
+	case opLt:
+		return clause.Lt{Column: col, Value: c.values[0]}
+	case opLte:
+		return clause.Lte{Column: col, Value: c.values[0]}
+	case opIn:
+		return clause.IN{Column: col, Values: c.values}
+	case opLike:
+		return clause.Like{Column: col, Value: c.values[0]}
+	case opILike:
+		if dialect == "postgres" {
+			return clause.Expr{SQL: "? ILIKE ?", Vars: []interface{}{col, c.values[0]}}
+		}
+		return clause.Expr{SQL: "LOWER(?) LIKE LOWER(?)", Vars: []interface{}{col, c.values[0]}}
+	case opBetween:
+		return clause.Expr{SQL: "? BETWEEN ? AND ?", Vars: []interface{}{col, c.values[0], c.values[1]}}
+	case opIsNull:
+		return clause.Eq{Column: col, Value: nil}
+	case opNotNull:
+		return clause.Neq{Column: col, Value: nil}
+	case opAnd, opOr:
+		exprs := make([]clause.Expression, len(c.conds))
+		for i, cond := range c.conds {
+			exprs[i] = cond.expression(dialect)
+		}
+		if c.op == opOr {
+			return clause.Or(exprs...)
+		}
+		return clause.And(exprs...)
+	default:
+		return clause.Expr{SQL: "(" + c.column + ")", Vars: c.values}
+	}
+}
+
+type order struct {
+	column string
+	desc   bool
+}
 
 type Query struct {
-	subject  interface{}
 	offset   int
 	limit    int
-	sort     interface{}
-	args     []interface{}
-	where    string
-	whereMap *h.Map
+	conds    []Cond
+	whereMap []Cond
+	where    *Cond
+	orders   []order
+	fields   []string
+	joins    []association
+	preloads []association
+}
+
+type association struct {
+	name string
+	args []interface{}
 }
 
 type Result struct {
@@ -26,9 +163,11 @@ func (q *Query) Limit(value int) *Query {
 	q.limit = value
 	return q
 }
+
+// Page selects the page-th page (starting at 0) of size rows.
 func (q *Query) Page(page int, size int) *Query {
-	q.offset = page * size - 1
-	q.limit = size - 1
+	q.offset = page * size
+	q.limit = size
 	return q
 }
 
@@ -37,18 +176,139 @@ func (q *Query) Offset(value int) *Query {
 	return q
 }
 
+// Where adds conditions, they are combined with AND.
+func (q *Query) Where(conds ...Cond) *Query {
+	q.conds = append(q.conds, conds...)
+	return q
+}
+
+// W sets an equality condition for each entry of where, it replaces the ones of
+// a previous call (see AndW).
 func (q *Query) W(where h.Map) *Query {
-	q.whereMap = &where
+	q.whereMap = eqConds(where)
 	return q
 }
 
+// AndW adds an equality condition for each entry of where.
+func (q *Query) AndW(where h.Map) *Query {
+	return q.Where(eqConds(where)...)
+}
+
+// Wheres sets a raw condition, it replaces the one of a previous call (see
+// AndWheres).
 func (q *Query) Wheres(where string, args ...interface{}) *Query {
-	q.where = where
-	q.args = args
+	cond := Raw(where, args...)
+	q.where = &cond
+	return q
+}
+
+// AndWheres adds a raw condition.
+func (q *Query) AndWheres(where string, args ...interface{}) *Query {
+	return q.Where(Raw(where, args...))
+}
+
+// conditions returns the conditions set by W, Wheres and Where.
+func (q *Query) conditions() []Cond {
+	conds := append(append([]Cond{}, q.whereMap...), q.conds...)
+	if q.where != nil {
+		conds = append(conds, *q.where)
+	}
+	return conds
+}
+
+// eqConds sorts the columns so that the generated sql is stable, they are not
+// checked: like gorm map conditions, the keys are trusted column names.
+func eqConds(where h.Map) []Cond {
+	columns := make([]string, 0, len(where))
+	for column := range where {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	conds := make([]Cond, len(columns))
+	for i, column := range columns {
+		conds[i] = Cond{op: opEq, column: column, values: []interface{}{where[column]}}
+	}
+	return conds
+}
+
+// Sort adds sort columns, given as "name", "name desc" or "-name", several
+// columns can be separated with commas.
+func (q *Query) Sort(fields ...string) *Query {
+	for _, field := range fields {
+		for _, part := range strings.Split(field, ",") {
+			tokens := strings.Fields(part)
+			if len(tokens) == 0 {
+				continue
+			}
+			column, desc := tokens[0], false
+			if strings.HasPrefix(column, "-") {
+				column, desc = column[1:], true
+			}
+			if len(tokens) > 1 {
+				desc = strings.EqualFold(tokens[1], "desc")
+			}
+			q.OrderBy(column, desc)
+		}
+	}
+	return q
+}
+
+func (q *Query) OrderBy(column string, desc bool) *Query {
+	q.orders = append(q.orders, order{column: checkColumn(column), desc: desc})
+	return q
+}
+
+// Select restricts the columns loaded.
+func (q *Query) Select(fields ...string) *Query {
+	for _, field := range fields {
+		q.fields = append(q.fields, checkColumn(field))
+	}
+	return q
+}
+
+// Joins joins a (belongs to / has one) association by name, or a raw join.
+func (q *Query) Joins(name string, args ...interface{}) *Query {
+	q.joins = append(q.joins, association{name: name, args: args})
 	return q
 }
 
-func (q *Query) Sort(field string) *Query {
-	q.sort = field
+// Preload loads an association with a separate query.
+func (q *Query) Preload(name string, args ...interface{}) *Query {
+	q.preloads = append(q.preloads, association{name: name, args: args})
 	return q
 }
+
+// filter applies the joins and conditions of the query.
+func (q *Query) filter(conn *gorm.DB) *gorm.DB {
+	for _, join := range q.joins {
+		conn = conn.Joins(join.name, join.args...)
+	}
+	dialect := conn.Dialector.Name()
+	for _, cond := range q.conditions() {
+		conn = conn.Where(cond.expression(dialect))
+	}
+	return conn
+}
+
+// apply applies the whole query, for finds.
+func (q *Query) apply(conn *gorm.DB) *gorm.DB {
+	conn = q.filter(conn).Offset(q.offset).Limit(q.limit)
+	if len(q.fields) > 0 {
+		conn = conn.Select(q.fields)
+	}
+	for _, o := range q.orders {
+		conn = conn.Order(clause.OrderByColumn{Column: clause.Column{Name: o.column}, Desc: o.desc})
+	}
+	for _, preload := range q.preloads {
+		conn = conn.Preload(preload.name, preload.args...)
+	}
+	return conn
+}
+
+// checkColumn returns column without its quotes, the dialect quotes it.
+func checkColumn(column string) string {
+	if !columnPattern.MatchString(column) {
+		errors.Raise(errors.NewFunctionalError(ErrInvalidColumnCode, "invalid column: "+column))
+	}
+	return strings.NewReplacer(`"`, "", "`", "").Replace(column)
+}
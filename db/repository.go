@@ -0,0 +1,117 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/soffa-io/soffa-core-go/h"
+)
+
+// Repository gives a typed access to the models T. Like Link, failures are
+// raised, a missing model is reported with an ErrNotFoundCode functional error.
+type Repository[T any] interface {
+	Get(id string) T
+	FindAll(query *Query) []T
+	// Page returns the models of page (starting at 0) with their total count.
+	Page(query *Query, page int, size int) Page[T]
+	// Cursor returns the models following cursor, see FindCursor.
+	Cursor(query *Query, cursor string, size int) CursorPage[T]
+	Insert(model *T)
+	Update(model *T)
+	Delete(id string)
+	Exists(id string) bool
+	Count(query *Query) int64
+	// With binds the repository to link, a tenant or a transaction.
+	With(link *Link) Repository[T]
+	Tenant(tenant string) Repository[T]
+	// WithContext routes the repository to the transaction or tenant of ctx.
+	WithContext(ctx context.Context) Repository[T]
+}
+
+type linkRepository[T any] struct {
+	link *Link
+}
+
+func NewRepository[T any](link *Link) Repository[T] {
+	return &linkRepository[T]{link: link}
+}
+
+func (r *linkRepository[T]) Get(id string) T {
+	var model T
+	if !r.link.FindById(&model, id) {
+		raiseNotFound[T](id)
+	}
+	return model
+}
+
+func (r *linkRepository[T]) FindAll(query *Query) []T {
+	models := []T{}
+	r.link.Find(&models, orAll(query))
+	return models
+}
+
+func (r *linkRepository[T]) Page(query *Query, page int, size int) Page[T] {
+	return FindPage[T](r.link, query, page, size)
+}
+
+func (r *linkRepository[T]) Cursor(query *Query, cursor string, size int) CursorPage[T] {
+	return FindCursor[T](r.link, query, cursor, size)
+}
+
+func (r *linkRepository[T]) Insert(model *T) {
+	r.link.Create(model)
+}
+
+func (r *linkRepository[T]) Update(model *T) {
+	count, err := r.link.base.Update(model)
+	errors.Raise(err)
+	if count == 0 {
+		errors.Raise(errors.NewFunctionalError(errors.ErrNotFoundCode, "model to update not found"))
+	}
+}
+
+func (r *linkRepository[T]) Delete(id string) {
+	var model T
+	count, err := r.link.base.Delete(&model, *Q().W(h.Map{"id": id}))
+	errors.Raise(err)
+	if count == 0 {
+		raiseNotFound[T](id)
+	}
+}
+
+func (r *linkRepository[T]) Exists(id string) bool {
+	var model T
+	return r.link.ExistsById(&model, id)
+}
+
+func (r *linkRepository[T]) Count(query *Query) int64 {
+	var model T
+	return r.link.Count(&model, query)
+}
+
+func (r *linkRepository[T]) With(link *Link) Repository[T] {
+	return &linkRepository[T]{link: link}
+}
+
+func (r *linkRepository[T]) Tenant(tenant string) Repository[T] {
+	return r.With(r.link.Tenant(tenant))
+}
+
+func (r *linkRepository[T]) WithContext(ctx context.Context) Repository[T] {
+	return r.With(LinkFrom(ctx, r.link))
+}
+
+func orAll(query *Query) *Query {
+	if query == nil {
+		return Q()
+	}
+	return query
+}
+
+func raiseNotFound[T any](id string) {
+	var model T
+	name := reflect.TypeOf(model).Name()
+	errors.Raise(errors.NewFunctionalError(errors.ErrNotFoundCode, fmt.Sprintf("%s not found: %s", name, id)))
+}
@@ -0,0 +1,335 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sctx "github.com/soffa-io/soffa-core-go/context"
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/soffa-io/soffa-core-go/h"
+	"gorm.io/gorm/schema"
+)
+
+type memoryStore[T any] struct {
+	mu      sync.RWMutex
+	tenants map[string]map[string]T
+}
+
+type memoryRepository[T any] struct {
+	store  *memoryStore[T]
+	tenant string
+}
+
+// NewMemoryRepository returns a Repository backed by a map, meant for unit
+// tests. Query conditions are evaluated in memory, except Raw ones.
+func NewMemoryRepository[T any](models ...T) Repository[T] {
+	r := &memoryRepository[T]{store: &memoryStore[T]{tenants: map[string]map[string]T{}}}
+	for i := range models {
+		r.Insert(&models[i])
+	}
+	return r
+}
+
+func (r *memoryRepository[T]) models() map[string]T {
+	models, ok := r.store.tenants[r.tenant]
+	if !ok {
+		models = map[string]T{}
+		r.store.tenants[r.tenant] = models
+	}
+	return models
+}
+
+func (r *memoryRepository[T]) Get(id string) T {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+	model, ok := r.store.tenants[r.tenant][id]
+	if !ok {
+		raiseNotFound[T](id)
+	}
+	return model
+}
+
+func (r *memoryRepository[T]) FindAll(query *Query) []T {
+	query = orAll(query)
+	r.store.mu.RLock()
+	models := []T{}
+	for _, model := range r.store.tenants[r.tenant] {
+		if matches(model, query) {
+			models = append(models, model)
+		}
+	}
+	r.store.mu.RUnlock()
+	sortModels(models, query.orders)
+	if query.offset > 0 {
+		if query.offset >= len(models) {
+			return []T{}
+		}
+		models = models[query.offset:]
+	}
+	if query.limit >= 0 && query.limit < len(models) {
+		models = models[:query.limit]
+	}
+	return models
+}
+
+func (r *memoryRepository[T]) Page(query *Query, page int, size int) Page[T] {
+	return newPage(query, page, size, r.FindAll, r.Count)
+}
+
+func (r *memoryRepository[T]) Cursor(query *Query, cursor string, size int) CursorPage[T] {
+	return newCursorPage(query, cursor, size, r.FindAll)
+}
+
+func (r *memoryRepository[T]) Insert(model *T) {
+	id := modelId(*model)
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	models := r.models()
+	if _, exists := models[id]; exists {
+		errors.RaiseNew("duplicate id: %s", id)
+	}
+	models[id] = *model
+}
+
+func (r *memoryRepository[T]) Update(model *T) {
+	id := modelId(*model)
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	models := r.models()
+	if _, exists := models[id]; !exists {
+		raiseNotFound[T](id)
+	}
+	models[id] = *model
+}
+
+func (r *memoryRepository[T]) Delete(id string) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	models := r.models()
+	if _, exists := models[id]; !exists {
+		raiseNotFound[T](id)
+	}
+	delete(models, id)
+}
+
+func (r *memoryRepository[T]) Exists(id string) bool {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+	_, exists := r.store.tenants[r.tenant][id]
+	return exists
+}
+
+func (r *memoryRepository[T]) Count(query *Query) int64 {
+	q := *orAll(query)
+	return int64(len(r.FindAll(q.Offset(0).Limit(-1))))
+}
+
+// With has no effect, the memory repository is not transactional.
+func (r *memoryRepository[T]) With(link *Link) Repository[T] {
+	return r
+}
+
+func (r *memoryRepository[T]) Tenant(tenant string) Repository[T] {
+	return &memoryRepository[T]{store: r.store, tenant: tenant}
+}
+
+func (r *memoryRepository[T]) WithContext(ctx context.Context) Repository[T] {
+	if tenant := sctx.TenantFrom(ctx); !h.IsStrEmpty(tenant) {
+		return r.Tenant(tenant)
+	}
+	return r
+}
+
+// field returns the value of the struct field mapped to column, it is invalid
+// when the field is a nil pointer.
+func field(model interface{}, column string) (reflect.Value, bool) {
+	v := reflect.Indirect(reflect.ValueOf(model))
+	f, ok := columnField(v.Type(), column)
+	if !ok {
+		return reflect.Value{}, false
+	}
+	return reflect.Indirect(v.FieldByIndex(f.Index)), true
+}
+
+func columnField(t reflect.Type, column string) (reflect.StructField, bool) {
+	naming := schema.NamingStrategy{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if embedded, ok := columnField(f.Type, column); ok {
+				embedded.Index = append([]int{i}, embedded.Index...)
+				return embedded, true
+			}
+			continue
+		}
+		name := schema.ParseTagSetting(f.Tag.Get("gorm"), ";")["COLUMN"]
+		if name == "" {
+			name = naming.ColumnName("", f.Name)
+		}
+		if name == column || f.Name == column {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func modelId(model interface{}) string {
+	value, ok := field(model, "id")
+	if !ok {
+		errors.RaiseNew("%T has no id field", model)
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+func matches(model interface{}, query *Query) bool {
+	for _, cond := range query.conditions() {
+		if !cond.matches(model) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Cond) matches(model interface{}) bool {
+	switch c.op {
+	case opAnd:
+		for _, cond := range c.conds {
+			if !cond.matches(model) {
+				return false
+			}
+		}
+		return true
+	case opOr:
+		for _, cond := range c.conds {
+			if cond.matches(model) {
+				return true
+			}
+		}
+		return false
+	case opRaw:
+		errors.RaiseNew("raw conditions are not supported by the memory repository")
+	}
+	column := columnName(c.column)
+	value, ok := field(model, column)
+	if !ok {
+		errors.RaiseNew("%T has no column %s", model, column)
+	}
+	switch c.op {
+	case opIsNull:
+		return !value.IsValid()
+	case opNotNull:
+		return value.IsValid()
+	}
+	// like gorm, = nil and <> nil are IS NULL and IS NOT NULL, the other
+	// comparisons with nil match nothing (as with sql)
+	if (c.op == opEq || c.op == opNe) && h.IsNil(c.values[0]) {
+		return value.IsValid() == (c.op == opNe)
+	}
+	if !value.IsValid() {
+		return false
+	}
+	for _, v := range c.values {
+		if h.IsNil(v) && c.op != opIn {
+			return false
+		}
+	}
+	switch c.op {
+	case opEq:
+		return compare(value, c.values[0]) == 0
+	case opNe:
+		return compare(value, c.values[0]) != 0
+	case opGt:
+		return compare(value, c.values[0]) > 0
+	case opGte:
+		return compare(value, c.values[0]) >= 0
+	case opLt:
+		return compare(value, c.values[0]) < 0
+	case opLte:
+		return compare(value, c.values[0]) <= 0
+	case opBetween:
+		return compare(value, c.values[0]) >= 0 && compare(value, c.values[1]) <= 0
+	case opIn:
+		for _, v := range c.values {
+			if !h.IsNil(v) && compare(value, v) == 0 {
+				return true
+			}
+		}
+		return false
+	case opLike, opILike:
+		return like(fmt.Sprint(value.Interface()), fmt.Sprint(c.values[0]), c.op == opILike)
+	}
+	return false
+}
+
+func like(value string, pattern string, insensitive bool) bool {
+	if insensitive {
+		value, pattern = strings.ToLower(value), strings.ToLower(pattern)
+	}
+	expr := "^" + strings.NewReplacer("%", ".*", "_", ".").Replace(regexp.QuoteMeta(pattern)) + "$"
+	return regexp.MustCompile(expr).MatchString(value)
+}
+
+// compare compares a field value with a query value, numbers and times are
+// compared as such, other values by their string form. nil is lower than any
+// value.
+func compare(value reflect.Value, other interface{}) int {
+	o := reflect.Indirect(reflect.ValueOf(other))
+	if !o.IsValid() {
+		return 1
+	}
+	if a, ok := number(value); ok {
+		if b, ok := number(o); ok {
+			return compareOrdered(a, b)
+		}
+	}
+	if a, ok := value.Interface().(time.Time); ok {
+		if b, ok := o.Interface().(time.Time); ok {
+			return compareOrdered(a.UnixNano(), b.UnixNano())
+		}
+	}
+	return strings.Compare(fmt.Sprint(value.Interface()), fmt.Sprint(o.Interface()))
+}
+
+func compareOrdered[N float64 | int64](a N, b N) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func number(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+func sortModels[T any](models []T, orders []order) {
+	sort.SliceStable(models, func(i, j int) bool {
+		for _, o := range orders {
+			a, _ := field(models[i], columnName(o.column))
+			b, _ := field(models[j], columnName(o.column))
+			if !a.IsValid() || !b.IsValid() {
+				continue
+			}
+			if c := compare(a, b.Interface()); c != 0 {
+				return (c < 0) != o.desc
+			}
+		}
+		return modelId(models[i]) < modelId(models[j])
+	})
+}
@@ -0,0 +1,411 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/soffa-io/soffa-core-go/h"
+	"github.com/soffa-io/soffa-core-go/log"
+	"gorm.io/gorm"
+)
+
+const (
+	TenantActive    = "active"
+	TenantSuspended = "suspended"
+	// TenantProvisioning is the status of a tenant until its schema is migrated
+	// and seeded.
+	TenantProvisioning = "provisioning"
+
+	TenantProvisionedEvent = "tenant.provisioned"
+	TenantSuspendedEvent   = "tenant.suspended"
+	TenantResumedEvent     = "tenant.resumed"
+	TenantDroppedEvent     = "tenant.dropped"
+
+	// ErrTenantRequiredCode is raised when a multitenant datasource is used
+	// without a tenant.
+	ErrTenantRequiredCode = errors.ErrTenantRequiredCode
+
+	tenantMigrationId = "soffa_tenants_0001"
+)
+
+var (
+	// TenantIdPattern restricts tenant ids to names that are valid schemas and
+	// databases in every dialect.
+	TenantIdPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+	// TenantCacheTTL is how long the suspended tenants are cached by a DS.
+	TenantCacheTTL = 30 * time.Second
+
+	reservedTenantIds = map[string]bool{
+		"public": true, "information_schema": true, "pg_catalog": true, "pg_toast": true,
+		"mysql": true, "sys": true, "performance_schema": true,
+		"master": true, "model": true, "msdb": true, "tempdb": true,
+	}
+)
+
+// Tenant is a tenant registered in a DS with TenantRegistry enabled.
+type Tenant struct {
+	Id        string `gorm:"primaryKey;size:63"`
+	Status    string `gorm:"size:16;not null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (Tenant) TableName() string {
+	return "soffa_tenants"
+}
+
+// TenantEvent is emitted when the lifecycle of a tenant changes, the
+// application publishes it on the broker (see Manager.OnTenantEvent).
+type TenantEvent struct {
+	Type       string    `json:"type"`
+	Datasource string    `json:"datasource"`
+	Tenant     string    `json:"tenant"`
+	At         time.Time `json:"at"`
+}
+
+// TenantError is the failure of a tenant migration.
+type TenantError struct {
+	Tenant string
+	Err    error
+}
+
+func (e TenantError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Tenant, e.Err)
+}
+
+// TenantMigration creates the tenants registry, it is applied automatically on
+// the default schema of a DS with TenantRegistry enabled.
+func TenantMigration() *gormigrate.Migration {
+	return &gormigrate.Migration{
+		ID: tenantMigrationId,
+		Migrate: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Tenant{})
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&Tenant{})
+		},
+	}
+}
+
+// ValidateTenantId raises a validation error when id can't be used as a schema
+// or database name, it is checked where the tenants get one.
+func ValidateTenantId(id string) {
+	if !TenantIdPattern.MatchString(id) {
+		errors.RaiseValidationError(fmt.Sprintf("invalid tenant id '%s', expected %s", id, TenantIdPattern))
+	}
+	if reservedTenantIds[id] {
+		errors.RaiseValidationError(fmt.Sprintf("tenant id '%s' is reserved", id))
+	}
+}
+
+// validateSchema checks the tenants isolated in a schema (or database) of their
+// own, the other ones never end up in an identifier.
+func (ds *DS) validateSchema(tenant string) {
+	if ds.system.supportsSchemas() {
+		ValidateTenantId(tenant)
+	}
+}
+
+type tenantCache struct {
+	mu        sync.Mutex
+	suspended map[string]bool
+	loadedAt  time.Time
+}
+
+// OnTenantEvent registers the listener of the tenant lifecycle events of all
+// the datasources.
+func (m *Manager) OnTenantEvent(listener func(event TenantEvent)) {
+	m.tenantListener = listener
+	for _, ds := range m.ds {
+		ds.tenantListener = listener
+	}
+}
+
+// ProvisionTenant creates the schema (or database) of the tenant, applies the
+// migrations and runs seed in a transaction of the tenant. With the registry,
+// the tenant is registered first so that concurrent provisionings of the same
+// id conflict, it becomes active once provisioned.
+func (l *Link) ProvisionTenant(id string, seed ...func(tx *Link)) {
+	ds := l.ds
+	if h.IsStrEmpty(id) {
+		errors.RaiseValidationError("tenant id is required")
+	}
+	ds.validateSchema(id)
+	if ds.TenantRegistry {
+		if err := ds.system.base.Create(&Tenant{Id: id, Status: TenantProvisioning}); err != nil {
+			if tenant, ok := ds.findTenant(id); ok {
+				errors.RaiseValidationError(fmt.Sprintf("tenant %s already exists (%s)", id, tenant.Status))
+			}
+			errors.Raise(err)
+		}
+	}
+	l.provisionSchema(id, seed)
+	if ds.TenantRegistry {
+		ds.setTenantStatus(id, TenantProvisioning, TenantActive)
+	}
+	log.Default.Infof("[%s] tenant %s provisioned", ds.Id, id)
+	ds.emitTenantEvent(TenantProvisionedEvent, id)
+}
+
+// provisionSchema creates, migrates and seeds the schema of the tenant. When it
+// fails, the schema it created and the registration are removed so that the
+// provisioning can be retried from scratch.
+func (l *Link) provisionSchema(id string, seed []func(tx *Link)) {
+	ds := l.ds
+	created := false
+	defer func() {
+		if r := recover(); r != nil {
+			log.Default.Errorf("[%s] provisioning of tenant %s failed, rolling back -- %v", ds.Id, id, r)
+			if created {
+				log.Default.ErrorIf(ds.system.base.(*GormLink).dropSchema(id), "[%s] unable to drop the schema of tenant %s", ds.Id, id)
+			}
+			if ds.TenantRegistry {
+				_, err := ds.system.base.Delete(&Tenant{}, *Q().W(h.Map{"id": id}))
+				log.Default.ErrorIf(err, "[%s] unable to unregister tenant %s", ds.Id, id)
+			}
+			panic(r)
+		}
+	}()
+	if ds.system.supportsSchemas() {
+		exists, err := ds.dialect.exists(ds.system.base.(*GormLink).conn, id)
+		errors.Raise(err)
+		ds.system.createSchemas(id)
+		created = !exists
+	}
+	ds.migrateSchema(id)
+	for _, fn := range seed {
+		l.Tenant(id).Transactional(fn)
+	}
+}
+
+// SuspendTenant rejects the operations of the tenant until it is resumed.
+func (l *Link) SuspendTenant(id string) {
+	l.ds.setTenantStatus(id, TenantActive, TenantSuspended)
+	l.ds.emitTenantEvent(TenantSuspendedEvent, id)
+}
+
+func (l *Link) ResumeTenant(id string) {
+	l.ds.setTenantStatus(id, TenantSuspended, TenantActive)
+	l.ds.emitTenantEvent(TenantResumedEvent, id)
+}
+
+// DropTenant drops the schema (or database, or rows with IsolationRow) of a
+// suspended tenant and removes it from the registry, the data is lost.
+func (l *Link) DropTenant(id string) {
+	ds := l.ds
+	ds.requireRegistry()
+	if !ds.system.supportsSchemas() && !ds.rowIsolation() {
+		errors.RaiseNew("[%s] tenants share the database with %s, they can't be dropped", ds.Id, ds.dialect.name)
+	}
+	if id == ds.defaultSchema {
+		errors.RaiseValidationError(fmt.Sprintf("tenant %s is the default database of %s", id, ds.Id))
+	}
+	tenant, ok := ds.findTenant(id)
+	if !ok {
+		errors.RaiseErrNotFound(fmt.Sprintf("tenant %s not found", id))
+	}
+	if tenant.Status == TenantActive {
+		// a tenant left in provisioning by a crash can be dropped as well
+		errors.RaiseValidationError(fmt.Sprintf("tenant %s must be suspended before it is dropped", id))
+	}
+	if ds.rowIsolation() {
+		errors.Raisef(ds.deleteTenantRows(id), "[%s] unable to delete the rows of tenant %s", ds.Id, id)
+	} else {
+		errors.Raisef(ds.system.base.(*GormLink).dropSchema(id), "[%s] unable to drop tenant %s", ds.Id, id)
+	}
+	_, err := ds.system.base.Delete(&Tenant{}, *Q().W(h.Map{"id": id}))
+	errors.Raise(err)
+	ds.tenants.invalidate()
+	log.Default.Warnf("[%s] tenant %s dropped", ds.Id, id)
+	ds.emitTenantEvent(TenantDroppedEvent, id)
+}
+
+// Tenants returns the registered tenants.
+func (l *Link) Tenants() []Tenant {
+	l.ds.requireRegistry()
+	var tenants []Tenant
+	l.ds.system.Find(&tenants, Q().Sort("id"))
+	return tenants
+}
+
+// EachTenant calls fn with the link of every active tenant of a multitenant
+// datasource, or once with l and no tenant.
+func (l *Link) EachTenant(fn func(tenant string, link *Link)) {
+	if !l.ds.multitenant() {
+		fn("", l)
+		return
+	}
+	for _, tenant := range l.ds.tenantIds() {
+		if l.ds.TenantRegistry && l.ds.tenants.isSuspended(l.ds, tenant) {
+			continue
+		}
+		fn(tenant, l.Tenant(tenant))
+	}
+}
+
+// MigrateTenants applies the migrations on tenants in parallel, the failures
+// are returned for each tenant.
+func (l *Link) MigrateTenants(tenants ...string) []TenantError {
+	return l.ds.migrateTenants(l.ds.migrations(), tenants)
+}
+
+func (ds *DS) migrateTenants(migrations []*gormigrate.Migration, tenants []string) []TenantError {
+	workers := ds.MigrationWorkers
+	if workers <= 0 {
+		workers = 4
+	}
+	if !ds.system.supportsSchemas() {
+		// tenants share the database
+		workers = 1
+	}
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []TenantError
+		queue  = make(chan string)
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for tenant := range queue {
+				if err := ds.migrateTenant(migrations, tenant); err != nil {
+					log.Default.With("tenant", tenant).Errorf("[%s] migrations failed -- %v", ds.Id, err)
+					mu.Lock()
+					failed = append(failed, TenantError{Tenant: tenant, Err: err})
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, tenant := range tenants {
+		queue <- tenant
+	}
+	close(queue)
+	wg.Wait()
+	sort.Slice(failed, func(i, j int) bool {
+		return failed[i].Tenant < failed[j].Tenant
+	})
+	return failed
+}
+
+func (ds *DS) migrateTenant(migrations []*gormigrate.Migration, tenant string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = errors.Errorf("%v", r)
+			}
+		}
+	}()
+	ds.validateSchema(tenant)
+	log.Default.Infof("applying migrations on schema %s", tenant)
+	ds.internalMigrations(migrations, tenant)
+	return nil
+}
+
+func (ds *DS) raiseTenantErrors(failed []TenantError) {
+	if len(failed) == 0 {
+		return
+	}
+	messages := make([]string, len(failed))
+	for i, f := range failed {
+		messages[i] = f.Error()
+	}
+	errors.RaiseNew("[%s] migrations failed on %d tenant(s): %s", ds.Id, len(failed), strings.Join(messages, "; "))
+}
+
+func (ds *DS) migrateRegistry() {
+	if ds.TenantRegistry {
+		ds.internalMigrations([]*gormigrate.Migration{TenantMigration()}, "")
+	}
+}
+
+// registeredTenants returns the ids of the registered tenants, suspended ones
+// included. The tenants being provisioned are migrated by ProvisionTenant.
+func (ds *DS) registeredTenants() []string {
+	var ids []string
+	conn := ds.system.base.(*GormLink).conn
+	if conn.Migrator().HasTable(&Tenant{}) {
+		errors.Raise(conn.Model(&Tenant{}).Where("status <> ?", TenantProvisioning).Pluck("id", &ids).Error)
+	}
+	return ids
+}
+
+func (ds *DS) requireRegistry() {
+	if !ds.TenantRegistry {
+		errors.RaiseNew("[%s] TenantRegistry is not enabled", ds.Id)
+	}
+}
+
+func (ds *DS) findTenant(id string) (Tenant, bool) {
+	var tenant Tenant
+	found := ds.system.FindById(&tenant, id)
+	return tenant, found
+}
+
+func (ds *DS) setTenantStatus(id string, from string, to string) {
+	ds.requireRegistry()
+	tenant, ok := ds.findTenant(id)
+	if !ok {
+		errors.RaiseErrNotFound(fmt.Sprintf("tenant %s not found", id))
+	}
+	if tenant.Status != from {
+		errors.RaiseValidationError(fmt.Sprintf("tenant %s is %s", id, tenant.Status))
+	}
+	tenant.Status = to
+	ds.system.Save(&tenant)
+	ds.tenants.invalidate()
+	log.Default.Infof("[%s] tenant %s %s", ds.Id, id, to)
+}
+
+func (ds *DS) emitTenantEvent(eventType string, tenant string) {
+	if ds.tenantListener == nil {
+		return
+	}
+	ds.tenantListener(TenantEvent{Type: eventType, Datasource: ds.Id, Tenant: tenant, At: time.Now().UTC()})
+}
+
+// checkTenant fails for the suspended tenants of the registry.
+func (ds *DS) checkTenant(tenant string) error {
+	if !ds.TenantRegistry {
+		return nil
+	}
+	if ds.tenants.isSuspended(ds, tenant) {
+		return errors.NewFunctionalError(errors.ErrForbiddenCode, fmt.Sprintf("tenant %s is suspended", tenant))
+	}
+	return nil
+}
+
+func (c *tenantCache) isSuspended(ds *DS, tenant string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.suspended == nil || time.Since(c.loadedAt) > TenantCacheTTL {
+		var ids []string
+		base := ds.system.base.(*GormLink)
+		err := base.conn.Model(&Tenant{}).Where("status = ?", TenantSuspended).Pluck("id", &ids).Error
+		if err != nil {
+			// the registry is not migrated yet
+			return false
+		}
+		c.suspended = map[string]bool{}
+		for _, id := range ids {
+			c.suspended[id] = true
+		}
+		c.loadedAt = time.Now()
+	}
+	return c.suspended[tenant]
+}
+
+func (c *tenantCache) invalidate() {
+	c.mu.Lock()
+	c.suspended = nil
+	c.mu.Unlock()
+}
@@ -1,7 +1,10 @@
 package db
 
 import (
+	"context"
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+	"github.com/soffa-io/soffa-core-go/errors"
 	"time"
 )
 
@@ -16,6 +19,12 @@ type TimeSeries interface {
 	Save(entries []TimeSerieEntry)
 }
 
+// Pinger is implemented by the TimeSeries that can report their health, see
+// soffa.NewTimeSeriesHealthContributor.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
 type InfluxDBClient struct {
 	TimeSeries
 	Url    string
@@ -46,3 +55,20 @@ func (c *InfluxDBClient) Save(entries []TimeSerieEntry) {
 	}
 	writeAPI.Flush()
 }
+
+func (c *InfluxDBClient) Ping(ctx context.Context) error {
+	client := influxdb2.NewClient(c.Url, c.Token)
+	defer client.Close()
+	res, err := client.Health(ctx)
+	if err != nil {
+		return err
+	}
+	if res.Status != domain.HealthCheckStatusPass {
+		msg := ""
+		if res.Message != nil {
+			msg = *res.Message
+		}
+		return errors.Errorf("influxdb is unhealthy: %s", msg)
+	}
+	return nil
+}
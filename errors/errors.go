@@ -17,17 +17,23 @@ func New(message string) error {
 }
 
 func IsTechnicalErr(err error) bool {
-	return Is(err, ErrTechnical{})
+	var target ErrTechnical
+	return As(err, &target)
 }
 
 func IsFunctionalErr(err error) bool {
-	return Is(err, ErrFunctional{})
+	var target ErrFunctional
+	return As(err, &target)
 }
 
 func Is(err error, target error) bool {
 	return e.Is(err, target)
 }
 
+func As(err error, target interface{}) bool {
+	return e.As(err, target)
+}
+
 func Unwrap(err error) error {
 	res := e.Unwrap(err)
 	if res == nil {
@@ -1,9 +1,13 @@
 package errors
 
 const (
-	ErrNotFoundCode  = "F404"
-	ErrForbiddenCode = "F403"
+	ErrNotFoundCode     = "F404"
+	ErrForbiddenCode    = "F403"
 	ErrUnauthorizedCode = "F401"
+	ErrConflictCode     = "F409"
+	// ErrTenantRequiredCode is a misconfiguration, a multitenant datasource is
+	// used without a tenant
+	ErrTenantRequiredCode = "TTNT"
 )
 
 type ErrFunctional struct {
@@ -21,3 +21,12 @@ func JoinPath(values ...string) string {
 func IsSamePath(value1 string, value2 string) bool {
 	return strings.EqualFold(regexCleanPath.ReplaceAllString(value1, ""), regexCleanPath.ReplaceAllString(value2, ""))
 }
+
+func ContainsStr(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
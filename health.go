@@ -0,0 +1,219 @@
+package soffa
+
+import (
+	"context"
+	gohttp "net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/soffa-io/soffa-core-go/broker"
+	"github.com/soffa-io/soffa-core-go/db"
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/soffa-io/soffa-core-go/h"
+	"github.com/soffa-io/soffa-core-go/http"
+)
+
+const (
+	StatusUp   = "UP"
+	StatusDown = "DOWN"
+)
+
+// HealthContributor is a component that takes part in the readiness of the
+// application (database, broker, downstream services, ...).
+type HealthContributor interface {
+	Name() string
+	Check(ctx context.Context) error
+}
+
+// HealthTimeout can be implemented by a HealthContributor to override the
+// default per-check timeout.
+type HealthTimeout interface {
+	Timeout() time.Duration
+}
+
+type healthContributor struct {
+	name    string
+	timeout time.Duration
+	check   func(ctx context.Context) error
+}
+
+func (c healthContributor) Name() string {
+	return c.name
+}
+
+func (c healthContributor) Check(ctx context.Context) error {
+	return c.check(ctx)
+}
+
+func (c healthContributor) Timeout() time.Duration {
+	return c.timeout
+}
+
+// NewHealthContributor creates a contributor from a function, a zero timeout
+// means the application default is used.
+func NewHealthContributor(name string, timeout time.Duration, check func(ctx context.Context) error) HealthContributor {
+	return healthContributor{name: name, timeout: timeout, check: check}
+}
+
+// NewTimeSeriesHealthContributor checks ts when it implements db.Pinger, it is
+// always up otherwise.
+func NewTimeSeriesHealthContributor(name string, ts db.TimeSeries) HealthContributor {
+	return NewHealthContributor(name, 0, func(ctx context.Context) error {
+		if p, ok := ts.(db.Pinger); ok {
+			return p.Ping(ctx)
+		}
+		return nil
+	})
+}
+
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+func (h HealthCheck) get(err error) HealthCheck {
+	if err != nil {
+		h.Status = StatusDown
+		h.Message = err.Error()
+	} else {
+		h.Status = StatusUp
+	}
+	return h
+}
+
+func (a *App) AddHealthContributor(contributor HealthContributor) *App {
+	a.healthContributors = append(a.healthContributors, contributor)
+	return a
+}
+
+func (a *App) IsReady() bool {
+	return atomic.LoadInt32(&a.ready) == 1
+}
+
+func (a *App) IsStarted() bool {
+	return atomic.LoadInt32(&a.started) == 1
+}
+
+func (a *App) setStarted() {
+	atomic.StoreInt32(&a.started, 1)
+	atomic.StoreInt32(&a.ready, 1)
+}
+
+func (a *App) contributors() []HealthContributor {
+	var out []HealthContributor
+	if a.dbManager != nil {
+		out = append(out, NewHealthContributor("db", 0, func(ctx context.Context) error {
+			return a.dbManager.PingContext(ctx)
+		}))
+	}
+	if a.broker != nil {
+		out = append(out, NewHealthContributor("broker", 0, func(ctx context.Context) error {
+			if p, ok := a.broker.(broker.ContextPinger); ok {
+				return p.PingContext(ctx)
+			}
+			return a.broker.Ping()
+		}))
+	}
+	return append(out, a.healthContributors...)
+}
+
+func (a *App) runHealthCheck(contributor HealthContributor) HealthCheck {
+	timeout := a.healthTimeout
+	if t, ok := contributor.(HealthTimeout); ok && t.Timeout() > 0 {
+		timeout = t.Timeout()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- errors.Errorf("health check panicked: %v", r)
+			}
+		}()
+		done <- contributor.Check(ctx)
+	}()
+
+	check := HealthCheck{Name: contributor.Name()}
+	select {
+	case err := <-done:
+		return check.get(err)
+	case <-ctx.Done():
+		return check.get(errors.Errorf("health check timed out after %s", timeout))
+	}
+}
+
+func (a *App) getHealthCheck() (bool, []HealthCheck) {
+	contributors := a.contributors()
+	comps := make([]HealthCheck, len(contributors))
+
+	var wg sync.WaitGroup
+	for i, c := range contributors {
+		wg.Add(1)
+		go func(i int, c HealthContributor) {
+			defer wg.Done()
+			comps[i] = a.runHealthCheck(c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	allUp := true
+	for _, hc := range comps {
+		if hc.Status == StatusDown {
+			allUp = false
+			break
+		}
+	}
+
+	return allUp, comps
+}
+
+func (a *App) sendHealth(c *http.Context, up bool, checks []HealthCheck) {
+	status := StatusUp
+	code := gohttp.StatusOK
+	if !up {
+		status = StatusDown
+		code = gohttp.StatusServiceUnavailable
+	}
+	body := h.Map{
+		"application": a.Name,
+		"version":     a.Version,
+		"status":      status,
+	}
+	if checks != nil {
+		comps := map[string]HealthCheck{}
+		for _, c := range checks {
+			comps[c.Name] = c
+		}
+		body["components"] = comps
+	}
+	c.JSON(code, body)
+}
+
+func (a *App) handleHealthCheck(c *http.Context) {
+	allUp, checks := a.getHealthCheck()
+	a.sendHealth(c, allUp, checks)
+}
+
+// handleLiveness only reports that the process is able to serve requests,
+// dependencies are not checked so that a failing database does not get the
+// pod restarted.
+func (a *App) handleLiveness(c *http.Context) {
+	a.sendHealth(c, true, nil)
+}
+
+func (a *App) handleReadiness(c *http.Context) {
+	if !a.IsReady() {
+		a.sendHealth(c, false, []HealthCheck{{Name: "startup", Status: StatusDown, Message: "application is not ready"}})
+		return
+	}
+	allUp, checks := a.getHealthCheck()
+	a.sendHealth(c, allUp, checks)
+}
+
+func (a *App) handleStartup(c *http.Context) {
+	a.sendHealth(c, a.IsStarted(), nil)
+}
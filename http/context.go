@@ -1,6 +1,7 @@
 package http
 
 import (
+	stdcontext "context"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/soffa-io/soffa-core-go/context"
@@ -18,18 +19,42 @@ type Context struct {
 }
 
 func newContext(gin *gin.Context) *Context {
-	return &Context{gin: gin, Context: context.New()}
+	if gin.Request == nil {
+		return &Context{gin: gin, Context: context.New()}
+	}
+	return &Context{gin: gin, Context: context.From(gin.Request.Context())}
 }
 
 func (c *Context) Request() *http.Request {
 	return c.gin.Request
 }
 
+// SetTenant routes the request to the tenant, its context (see Ctx) carries it
+// to db.LinkFrom.
 func (c *Context) SetTenant(value string) *Context {
 	c.Context.Set("tenant", value)
+	c.Context.SetTenant(value)
+	c.gin.Set("tenant", value)
+	if c.gin.Request != nil {
+		c.gin.Request = c.gin.Request.WithContext(context.WithTenant(c.gin.Request.Context(), value))
+	}
 	return c
 }
 
+// setAuth authenticates the request, its context (see Ctx) carries the
+// username to the audit fields of the models.
+func (c *Context) setAuth(auth Authentication) {
+	c.gin.Set(AuthenticationKey, auth)
+	c.Context.SetPrincipal(auth.Username)
+	if c.gin.Request != nil {
+		c.gin.Request = c.gin.Request.WithContext(context.WithPrincipal(c.gin.Request.Context(), auth.Username))
+	}
+}
+
+// Ctx returns the context of the request, with its tenant and principal.
+func (c *Context) Ctx() stdcontext.Context {
+	return c.Context.Unwrap()
+}
 
 func (c *Context) Raw() *gin.Context {
 	return c.gin
@@ -264,7 +289,11 @@ func (c *Context) SendError(orig error) {
 		})
 	case errors.ErrTechnical:
 		sentry.CaptureException(err)
-		c.gin.JSON(http.StatusBadRequest, gin.H{
+		status := http.StatusBadRequest
+		if (err.(errors.ErrTechnical)).Code == errors.ErrTenantRequiredCode {
+			status = http.StatusInternalServerError
+		}
+		c.gin.JSON(status, gin.H{
 			"code":    (err.(errors.ErrTechnical)).Code,
 			"message": orig.Error(),
 		})
@@ -284,6 +313,9 @@ func (c *Context) SendError(orig error) {
 		if code == errors.ErrUnauthorizedCode {
 			status = http.StatusUnauthorized
 		}
+		if code == errors.ErrConflictCode {
+			status = http.StatusConflict
+		}
 		msg := h.Map{
 			"code":    code,
 			"message": orig.Error(),
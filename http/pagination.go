@@ -0,0 +1,105 @@
+package http
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/soffa-io/soffa-core-go/errors"
+)
+
+var (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// Pagination holds the `page` (starting at 0), `size`, `sort` and `cursor`
+// query params of a list request.
+type Pagination struct {
+	Page   int
+	Size   int
+	Sort   string
+	Cursor string
+}
+
+// PagedResult is implemented by db.Page.
+type PagedResult interface {
+	Pagination() (page int, size int, total int64)
+}
+
+// CursorResult is implemented by db.CursorPage.
+type CursorResult interface {
+	NextCursor() string
+}
+
+func (c *Context) Pagination() Pagination {
+	p := Pagination{
+		Page:   c.intQuery("page", 0),
+		Size:   c.intQuery("size", DefaultPageSize),
+		Sort:   c.Query("sort"),
+		Cursor: c.Query("cursor"),
+	}
+	if p.Page < 0 {
+		errors.RaiseValidationError("page must be positive")
+	}
+	if p.Size <= 0 || p.Size > MaxPageSize {
+		errors.RaiseValidationError(fmt.Sprintf("size must be between 1 and %d", MaxPageSize))
+	}
+	return p
+}
+
+func (c *Context) intQuery(name string, fallback int) int {
+	value := c.Query(name)
+	if value == "" {
+		return fallback
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		errors.RaiseValidationError(fmt.Sprintf("query param '%s' must be a number", name))
+	}
+	return i
+}
+
+// OKPage sends result with the X-Total-Count header and the first, prev, next
+// and last links.
+func (c *Context) OKPage(result PagedResult) {
+	page, size, total := result.Pagination()
+	c.gin.Header("X-Total-Count", strconv.FormatInt(total, 10))
+	last := 0
+	if total > 0 && size > 0 {
+		last = int((total - 1) / int64(size))
+	}
+	links := []string{c.pageLink("first", 0, size)}
+	if page > 0 {
+		links = append(links, c.pageLink("prev", page-1, size))
+	}
+	if page < last {
+		links = append(links, c.pageLink("next", page+1, size))
+	}
+	links = append(links, c.pageLink("last", last, size))
+	c.gin.Header("Link", strings.Join(links, ", "))
+	c.OK(result)
+}
+
+// OKCursor sends result with a next link when there is a following page.
+func (c *Context) OKCursor(result CursorResult) {
+	if next := result.NextCursor(); next != "" {
+		c.gin.Header("Link", c.link("next", url.Values{"cursor": {next}}))
+	}
+	c.OK(result)
+}
+
+func (c *Context) pageLink(rel string, page int, size int) string {
+	return c.link(rel, url.Values{"page": {strconv.Itoa(page)}, "size": {strconv.Itoa(size)}})
+}
+
+func (c *Context) link(rel string, params url.Values) string {
+	u := *c.gin.Request.URL
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	u.RawQuery = query.Encode()
+	return fmt.Sprintf("<%s>; rel=\"%s\"", u.RequestURI(), rel)
+}
@@ -1,10 +1,12 @@
 package http
 
 import (
+	"context"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/soffa-io/soffa-core-go/h"
+	"github.com/soffa-io/soffa-core-go/log"
 	swaggerFiles "github.com/swaggo/files"
 	swagger "github.com/swaggo/gin-swagger"
 	"net/http"
@@ -17,8 +19,10 @@ const (
 
 type Router struct {
 	engine  *gin.Engine
+	server  *http.Server
 	routes  []Route
 	filters []Filter
+	tenants TenantResolver
 }
 
 type Error struct {
@@ -151,6 +155,13 @@ func (r *Router) Use(handlers ...Filter) *Router {
 	return r
 }
 
+// ResolveTenant sets the tenant of each request with resolver, see
+// TenantStrategy.
+func (r *Router) ResolveTenant(resolver TenantResolver) *Router {
+	r.tenants = resolver
+	return r
+}
+
 func (r *Router) CRUD(base string, handler CrudHandler) {
 	r.CRUDWithOptions(base, handler, nil)
 }
@@ -171,6 +182,11 @@ func (r *Router) Add(route *Route) *Router {
 				c.SendError(err.(error))
 			}
 		}()
+		if r.tenants != nil {
+			if tenant := r.tenants(c); !h.IsStrEmpty(tenant) {
+				c.SetTenant(tenant)
+			}
+		}
 		route.Handler(c)
 	}
 
@@ -184,7 +200,34 @@ func (r *Router) Add(route *Route) *Router {
 	return r
 }
 
+// Start blocks until the server fails or Shutdown is called.
 func (r *Router) Start(port int) {
-	_ = r.engine.Run(fmt.Sprintf(":%d", port))
+	r.Listen(port)
+	r.Serve()
 }
 
+// Listen prepares the server of port, it must be called before Serve so that
+// Shutdown always sees the server.
+func (r *Router) Listen(port int) {
+	r.server = &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: r.engine,
+	}
+}
+
+// Serve blocks until the server prepared by Listen fails or Shutdown is called.
+func (r *Router) Serve() {
+	log.Default.Infof("http server listening on %s", r.server.Addr)
+	if err := r.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Default.Fatal(err)
+	}
+}
+
+// Shutdown stops accepting new connections and waits for in-flight requests
+// to complete, or for ctx to expire.
+func (r *Router) Shutdown(ctx context.Context) error {
+	if r.server == nil {
+		return nil
+	}
+	return r.server.Shutdown(ctx)
+}
@@ -65,7 +65,7 @@ func (f *JwtBearerFilter) Handle(c *Context) {
 			c.gin.AbortWithStatusJSON(http.StatusForbidden, h.Map{"message": "INVALID_AUDIENCE"})
 			return
 		}
-		c.gin.Set(AuthenticationKey, Authentication{
+		c.setAuth(Authentication{
 			Username:  decoded.Subject,
 			Principal: decoded,
 			Audience:  decoded.Audience,
@@ -0,0 +1,106 @@
+package http
+
+import (
+	"net"
+	"strings"
+
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/soffa-io/soffa-core-go/h"
+)
+
+// TenantResolver returns the tenant of a request, empty when it has none.
+type TenantResolver func(c *Context) string
+
+var (
+	TenantHeaders   = []string{"X-Tenant-Id", "X-TenantID"}
+	TenantClaim     = "tenant"
+	TenantPathParam = "tenant"
+)
+
+func HeaderTenant(names ...string) TenantResolver {
+	if len(names) == 0 {
+		names = TenantHeaders
+	}
+	return func(c *Context) string {
+		for _, name := range names {
+			if value := c.Header(name); !h.IsStrEmpty(value) {
+				return value
+			}
+		}
+		return ""
+	}
+}
+
+// ClaimTenant reads the tenant from a claim of the JWT, see JwtBearerFilter.
+func ClaimTenant(claim string) TenantResolver {
+	return func(c *Context) string {
+		auth := c.Auth()
+		if value, ok := auth.Claim(claim).(string); ok {
+			return value
+		}
+		return ""
+	}
+}
+
+// SubdomainTenant reads the tenant from the first label of hosts like
+// acme.api.example.com.
+func SubdomainTenant() TenantResolver {
+	return func(c *Context) string {
+		host := c.Request().Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		labels := strings.Split(host, ".")
+		if len(labels) < 3 || net.ParseIP(host) != nil {
+			return ""
+		}
+		return labels[0]
+	}
+}
+
+func PathTenant(param string) TenantResolver {
+	return func(c *Context) string {
+		return c.Param(param)
+	}
+}
+
+// FirstTenant returns the tenant of the first resolver that finds one.
+func FirstTenant(resolvers ...TenantResolver) TenantResolver {
+	return func(c *Context) string {
+		for _, resolve := range resolvers {
+			if tenant := resolve(c); !h.IsStrEmpty(tenant) {
+				return tenant
+			}
+		}
+		return ""
+	}
+}
+
+// TenantStrategy returns the resolver of a comma separated list of sources
+// tried in order: header[:name], claim[:name], subdomain, path[:param].
+func TenantStrategy(strategy string) TenantResolver {
+	var resolvers []TenantResolver
+	for _, source := range strings.Split(strategy, ",") {
+		kind, arg := strings.TrimSpace(source), ""
+		if i := strings.Index(kind, ":"); i >= 0 {
+			kind, arg = kind[:i], kind[i+1:]
+		}
+		switch kind {
+		case "header":
+			if arg == "" {
+				resolvers = append(resolvers, HeaderTenant())
+			} else {
+				resolvers = append(resolvers, HeaderTenant(arg))
+			}
+		case "claim":
+			resolvers = append(resolvers, ClaimTenant(h.AnyStr(arg, TenantClaim)))
+		case "subdomain":
+			resolvers = append(resolvers, SubdomainTenant())
+		case "path":
+			resolvers = append(resolvers, PathTenant(h.AnyStr(arg, TenantPathParam)))
+		default:
+			errors.RaiseNew("unknown tenant source: %s", source)
+		}
+	}
+	return FirstTenant(resolvers...)
+}
@@ -0,0 +1,60 @@
+package soffa
+
+import (
+	"time"
+
+	"github.com/soffa-io/soffa-core-go/broker"
+	"github.com/soffa-io/soffa-core-go/db"
+	"github.com/soffa-io/soffa-core-go/h"
+	"github.com/soffa-io/soffa-core-go/log"
+)
+
+// startOutboxRelay publishes the events written with Link.PublishEvent, it only
+// runs when a datasource has its outbox enabled and a broker is configured.
+func (a *App) startOutboxRelay() {
+	if a.dbManager == nil || !a.dbManager.HasOutbox() || a.outboxRelay != nil {
+		return
+	}
+	if a.broker == nil {
+		log.Default.Warn("outbox is enabled but no broker is configured, events will not be published")
+		return
+	}
+	def := db.DefaultOutboxConfig()
+	config := db.OutboxConfig{
+		Interval:    a.cfg.GetDuration(def.Interval, "outbox.interval", "OUTBOX_INTERVAL"),
+		BatchSize:   a.cfg.GetInt(def.BatchSize, "outbox.batch.size", "OUTBOX_BATCH_SIZE"),
+		MaxAttempts: a.cfg.GetInt(def.MaxAttempts, "outbox.max.attempts", "OUTBOX_MAX_ATTEMPTS"),
+		MaxBackoff:  a.cfg.GetDuration(def.MaxBackoff, "outbox.max.backoff", "OUTBOX_MAX_BACKOFF"),
+	}
+	a.outboxRelay = a.dbManager.StartOutboxRelay(a.publishOutboxEvent, config)
+}
+
+func (a *App) publishOutboxEvent(event db.OutboxEvent) error {
+	opts := []broker.PublishOption{
+		broker.WithMessageId(event.Id),
+		broker.WithTimeout(10 * time.Second),
+	}
+	if !h.IsStrEmpty(event.ContentType) {
+		if codec, ok := broker.LookupCodec(event.ContentType); ok {
+			opts = append(opts, broker.WithCodec(codec))
+		}
+	}
+	if !h.IsStrEmpty(event.Tenant) {
+		opts = append(opts, broker.WithTenant(event.Tenant))
+	}
+	for k, v := range event.GetHeaders() {
+		switch k {
+		case broker.HeaderCorrelationId:
+			opts = append(opts, broker.WithCorrelationId(v))
+		case broker.HeaderCausationId:
+			opts = append(opts, broker.WithCausationId(v))
+		case broker.HeaderEvent:
+			opts = append(opts, broker.WithEvent(v))
+		case broker.HeaderTenant:
+			opts = append(opts, broker.WithTenant(v))
+		default:
+			opts = append(opts, broker.WithHeader(k, v))
+		}
+	}
+	return a.broker.Publish(event.Subject, event.Payload, opts...)
+}
@@ -0,0 +1,313 @@
+package saga
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/soffa-io/soffa-core-go/broker"
+	"github.com/soffa-io/soffa-core-go/counters"
+	"github.com/soffa-io/soffa-core-go/db"
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/soffa-io/soffa-core-go/h"
+	"github.com/soffa-io/soffa-core-go/log"
+)
+
+var (
+	TransitionCounter   = counters.NewCounter("x_sys_saga_transition", "Will track saga transitions", true)
+	CompensationCounter = counters.NewCounter("x_sys_saga_compensation", "Will track compensated sagas", true)
+)
+
+// Manager runs the registered sagas: it subscribes to the events they expect
+// (as a queue group, so that each event is handled by one replica) and stores
+// their state with link.
+type Manager struct {
+	client      broker.Client
+	link        *db.Link
+	definitions map[string]*Definition
+	subjects    map[string][]*Definition
+}
+
+func NewManager(client broker.Client, link *db.Link) *Manager {
+	return &Manager{
+		client:      client,
+		link:        link,
+		definitions: map[string]*Definition{},
+		subjects:    map[string][]*Definition{},
+	}
+}
+
+func (m *Manager) Register(def Definition) *Manager {
+	def.validate()
+	if _, exists := m.definitions[def.Name]; exists {
+		errors.RaiseNew("saga %s is already registered", def.Name)
+	}
+	m.definitions[def.Name] = &def
+	for _, subject := range def.subjects() {
+		if _, subscribed := m.subjects[subject]; !subscribed {
+			subject := subject
+			m.client.SubscribeQueue(subject, "", func(msg broker.Message) interface{} {
+				m.handle(subject, msg)
+				return nil
+			})
+		}
+		m.subjects[subject] = append(m.subjects[subject], &def)
+	}
+	return m
+}
+
+// Get returns the state of the instance id of the saga name.
+func (m *Manager) Get(name string, id string) (*State, bool) {
+	var state State
+	if !m.link.First(&state, stateQuery(name, id)) {
+		return nil, false
+	}
+	return &state, true
+}
+
+func stateQuery(name string, id string) *db.Query {
+	return db.Q().W(h.Map{"id": id, "name": name})
+}
+
+func (m *Manager) handle(subject string, msg broker.Message) {
+	id := h.AnyStr(msg.CorrelationId, msg.ID)
+	if h.IsStrEmpty(id) {
+		log.Default.Warnf("[saga] message received on %s without correlation id, ignored", subject)
+		return
+	}
+	for _, def := range m.subjects[subject] {
+		if def.StartOn == subject {
+			m.start(def, id, msg)
+		} else {
+			m.transition(def, id, msg)
+		}
+	}
+}
+
+func (m *Manager) start(def *Definition, id string, msg broker.Message) {
+	m.run(id, msg.TenantId, db.LinkFrom(msg.Context(), m.link), msg, func(ctx *Context) bool {
+		if ctx.link.Exists(&State{}, stateQuery(def.Name, id)) {
+			log.Default.Infof("[saga:%s] %s already started, ignored", def.Name, id)
+			return false
+		}
+		ctx.State = &State{Id: id, Name: def.Name, Status: StatusRunning}
+		if def.Start != nil {
+			def.Start(ctx, msg)
+		} else {
+			ctx.State.Data = msg.Data
+		}
+		ctx.link.Create(ctx.State)
+		m.enter(def, ctx, def.Steps[0].Name)
+		m.apply(def, ctx)
+		return true
+	})
+}
+
+func (m *Manager) transition(def *Definition, id string, msg broker.Message) {
+	m.run(id, msg.TenantId, db.LinkFrom(msg.Context(), m.link), msg, func(ctx *Context) bool {
+		state := &State{}
+		if !ctx.link.First(state, stateQuery(def.Name, id)) {
+			return false
+		}
+		ctx.State = state
+		if state.Status != StatusRunning {
+			log.Default.Infof("[saga:%s] %s is %s, %s ignored", def.Name, id, state.Status, msg.Subject)
+			return false
+		}
+		step := def.step(state.Step)
+		handler := step.handler(msg.Subject)
+		if handler == nil {
+			log.Default.Warnf("[saga:%s] %s is not expected in step %s, ignored", def.Name, msg.Subject, state.Step)
+			return false
+		}
+		handler(ctx, msg)
+		m.apply(def, ctx)
+		return true
+	})
+}
+
+// CheckTimeouts fails the sagas whose current step timed out, in every tenant.
+// Schedule it with the application Scheduler.
+func (m *Manager) CheckTimeouts() {
+	m.link.EachTenant(func(tenant string, link *db.Link) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Default.With("tenant", tenant).Errorf("[saga] timeouts check failed -- %v", r)
+			}
+		}()
+		m.checkTimeouts(tenant, link)
+	})
+}
+
+func (m *Manager) checkTimeouts(tenant string, link *db.Link) {
+	var expired []State
+	link.Find(&expired, db.Q().Wheres("status = ? AND deadline < ?", StatusRunning, time.Now().UTC()))
+	for _, state := range expired {
+		def, ok := m.definitions[state.Name]
+		if !ok {
+			continue
+		}
+		m.run(state.Id, tenant, link, broker.Message{}, func(ctx *Context) bool {
+			current := &State{}
+			if !ctx.link.First(current, stateQuery(state.Name, state.Id)) || current.Status != StatusRunning || current.Deadline == nil || current.Deadline.After(time.Now().UTC()) {
+				return false
+			}
+			ctx.State = current
+			ctx.Fail(fmt.Sprintf("step %s timed out", current.Step))
+			m.apply(def, ctx)
+			return true
+		})
+	}
+}
+
+// run handles a message in a transaction of link (routed to the tenant), the
+// messages published by the saga are sent once the state is committed.
+func (m *Manager) run(id string, tenant string, link *db.Link, msg broker.Message, fn func(ctx *Context) bool) {
+	var ctx *Context
+	changed := false
+	func() {
+		defer func() {
+			TransitionCounter.Recover(recover(), true)
+		}()
+		link.Transactional(func(tx *db.Link) {
+			ctx = &Context{Message: msg, link: tx, tenant: tenant}
+			if changed = fn(ctx); changed {
+				tx.Save(ctx.State)
+				if tx.HasOutbox() {
+					for _, out := range ctx.outgoing {
+						tx.PublishEvent(out.subject, out.data, outboxHeaders(out.opts))
+					}
+				}
+			}
+		})
+	}()
+	if !changed || m.link.HasOutbox() {
+		return
+	}
+	for _, out := range ctx.outgoing {
+		if err := m.client.Publish(out.subject, out.data, out.opts...); err != nil {
+			log.Default.Wrapf(err, "[saga] %s: unable to publish %s", id, out.subject)
+		}
+	}
+}
+
+func (m *Manager) apply(def *Definition, ctx *Context) {
+	state := ctx.State
+	// the action of a step may move to the next one right away
+	for ctx.failure == "" && !ctx.done && ctx.next != "" {
+		next := ctx.next
+		ctx.next = ""
+		state.complete(state.Step)
+		m.enter(def, ctx, next)
+	}
+	if ctx.failure != "" {
+		m.compensate(def, ctx)
+	} else if ctx.done {
+		state.complete(state.Step)
+		state.Status = StatusCompleted
+		state.Deadline = nil
+		log.Default.Infof("[saga:%s] %s completed", def.Name, state.Id)
+	}
+}
+
+func (m *Manager) enter(def *Definition, ctx *Context, name string) {
+	step := def.step(name)
+	if step == nil {
+		errors.RaiseNew("[saga:%s] unknown step %s", def.Name, name)
+	}
+	ctx.State.Step = name
+	ctx.State.Deadline = nil
+	if step.Timeout > 0 {
+		deadline := time.Now().UTC().Add(step.Timeout)
+		ctx.State.Deadline = &deadline
+	}
+	log.Default.Infof("[saga:%s] %s entered step %s", def.Name, ctx.State.Id, name)
+	if step.Action != nil {
+		step.Action(ctx)
+	}
+}
+
+// compensate undoes the completed steps in reverse order. The saga is
+// compensated once every compensation succeeded, it fails otherwise and its
+// error lists the failed compensations.
+func (m *Manager) compensate(def *Definition, ctx *Context) {
+	state := ctx.State
+	state.Status = StatusCompensating
+	state.Error = ctx.failure
+	state.Deadline = nil
+	log.Default.Warnf("[saga:%s] %s failed in step %s -- %s", def.Name, state.Id, state.Step, ctx.failure)
+	completed := state.completedSteps()
+	var failures []string
+	for i := len(completed) - 1; i >= 0; i-- {
+		step := def.step(completed[i])
+		if step == nil || step.Compensate == nil {
+			continue
+		}
+		if err := m.compensateStep(step, ctx); err != nil {
+			log.Default.Errorf("[saga:%s] %s compensation of step %s failed -- %v", def.Name, state.Id, step.Name, err)
+			failures = append(failures, fmt.Sprintf("%s: %v", step.Name, err))
+		}
+	}
+	if len(failures) > 0 {
+		state.Status = StatusFailed
+		state.Error = fmt.Sprintf("%s (compensation failed: %s)", ctx.failure, strings.Join(failures, "; "))
+		return
+	}
+	state.Status = StatusCompensated
+}
+
+// compensateStep runs the compensation in a savepoint, the changes and the
+// messages of a failed compensation are discarded.
+func (m *Manager) compensateStep(step *Step, ctx *Context) (err error) {
+	link, outgoing := ctx.link, len(ctx.outgoing)
+	defer func() {
+		ctx.link = link
+		if re := recover(); re != nil {
+			err = errors.Errorf("%v", re)
+			ctx.outgoing = ctx.outgoing[:outgoing]
+		}
+		CompensationCounter.Record(err)
+	}()
+	link.Transactional(func(tx *db.Link) {
+		ctx.link = tx
+		step.Compensate(ctx)
+	})
+	return nil
+}
+
+func (s *Step) handler(subject string) func(ctx *Context, msg broker.Message) {
+	if s == nil {
+		return nil
+	}
+	if fn, ok := s.On[subject]; ok {
+		return fn
+	}
+	for pattern, fn := range s.On {
+		if broker.MatchSubject(pattern, subject) {
+			return fn
+		}
+	}
+	return nil
+}
+
+func outboxHeaders(opts []broker.PublishOption) map[string]string {
+	msg := broker.Message{}
+	for _, opt := range opts {
+		opt(&msg)
+	}
+	headers := map[string]string{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	for k, v := range map[string]string{
+		broker.HeaderCorrelationId: msg.CorrelationId,
+		broker.HeaderCausationId:   msg.CausationId,
+		broker.HeaderEvent:         msg.Event,
+		broker.HeaderTenant:        msg.TenantId,
+	} {
+		if v != "" {
+			headers[k] = v
+		}
+	}
+	return headers
+}
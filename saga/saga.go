@@ -0,0 +1,189 @@
+package saga
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"github.com/soffa-io/soffa-core-go/broker"
+	"github.com/soffa-io/soffa-core-go/db"
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/soffa-io/soffa-core-go/h"
+	"gorm.io/gorm"
+)
+
+const (
+	StatusRunning      = "running"
+	StatusCompleted    = "completed"
+	StatusCompensating = "compensating"
+	StatusCompensated  = "compensated"
+	StatusFailed       = "failed"
+)
+
+// State is the persisted state of a saga instance, its id is the correlation
+// id of the message that started it. Several sagas may start on the same
+// message, the instances are keyed by id and name.
+type State struct {
+	Id        string `gorm:"primaryKey;size:64"`
+	Name      string `gorm:"primaryKey;size:128"`
+	Step      string `gorm:"size:128"`
+	Status    string `gorm:"size:16;not null;index"`
+	Data      []byte
+	Completed string
+	Deadline  *time.Time `gorm:"index"`
+	Error     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (State) TableName() string {
+	return "saga_states"
+}
+
+// Migration creates the saga state table, add it to the migrations of the DS
+// given to the Manager.
+func Migration() *gormigrate.Migration {
+	return &gormigrate.Migration{
+		ID: "soffa_saga_0001",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&State{})
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&State{})
+		},
+	}
+}
+
+func (s *State) completedSteps() []string {
+	var steps []string
+	if s.Completed != "" {
+		_ = json.Unmarshal([]byte(s.Completed), &steps)
+	}
+	return steps
+}
+
+func (s *State) complete(step string) {
+	data, _ := json.Marshal(append(s.completedSteps(), step))
+	s.Completed = string(data)
+}
+
+// Definition describes a saga: a new instance starts when StartOn is received,
+// then it goes through Steps, starting with the first one.
+type Definition struct {
+	Name    string
+	StartOn string
+	// Start initializes the saga data from the start message, by default the
+	// (JSON) payload of the message is kept as is.
+	Start func(ctx *Context, msg broker.Message)
+	Steps []Step
+}
+
+// Step is a state of the saga. Action is run when the step is entered (it
+// usually publishes a command), On maps the events expected in this step to the
+// transition they trigger. Compensate undoes the step when a later step fails.
+// When Timeout is set and the step is not left in time, the saga fails.
+type Step struct {
+	Name       string
+	Action     func(ctx *Context)
+	On         map[string]func(ctx *Context, msg broker.Message)
+	Compensate func(ctx *Context)
+	Timeout    time.Duration
+}
+
+func (d *Definition) step(name string) *Step {
+	for i := range d.Steps {
+		if d.Steps[i].Name == name {
+			return &d.Steps[i]
+		}
+	}
+	return nil
+}
+
+func (d *Definition) subjects() []string {
+	subjects := []string{d.StartOn}
+	for _, step := range d.Steps {
+		for subject := range step.On {
+			subjects = appendUnique(subjects, subject)
+		}
+	}
+	return subjects
+}
+
+func (d *Definition) validate() {
+	if d.Name == "" || d.StartOn == "" || len(d.Steps) == 0 {
+		errors.RaiseNew("saga definition requires a name, a start subject and at least one step")
+	}
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+type outgoing struct {
+	subject string
+	data    interface{}
+	opts    []broker.PublishOption
+}
+
+// Context gives access to the saga instance while it handles a message.
+type Context struct {
+	State    *State
+	Message  broker.Message
+	link     *db.Link
+	tenant   string
+	outgoing []outgoing
+	next     string
+	done     bool
+	failure  string
+}
+
+func (c *Context) Id() string {
+	return c.State.Id
+}
+
+// Link is the transaction in which the saga state is saved.
+func (c *Context) Link() *db.Link {
+	return c.link
+}
+
+func (c *Context) Data(dest interface{}) error {
+	if len(c.State.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(c.State.Data, dest)
+}
+
+func (c *Context) SetData(data interface{}) {
+	encoded, err := json.Marshal(data)
+	errors.Raise(err)
+	c.State.Data = encoded
+}
+
+// Publish sends a message correlated to the saga once its state is saved (via
+// the outbox when the datasource has one).
+func (c *Context) Publish(subject string, data interface{}, opts ...broker.PublishOption) {
+	opts = append([]broker.PublishOption{broker.WithCorrelationId(c.State.Id), broker.WithCausationId(c.Message.ID)}, opts...)
+	if !h.IsStrEmpty(c.tenant) {
+		// the replies are handled in the tenant of the saga
+		opts = append([]broker.PublishOption{broker.WithTenant(c.tenant)}, opts...)
+	}
+	c.outgoing = append(c.outgoing, outgoing{subject: subject, data: data, opts: opts})
+}
+
+func (c *Context) Goto(step string) {
+	c.next = step
+}
+
+func (c *Context) Complete() {
+	c.done = true
+}
+
+// Fail stops the saga and compensates the completed steps in reverse order.
+func (c *Context) Fail(reason string) {
+	c.failure = reason
+}
@@ -0,0 +1,22 @@
+package soffa
+
+import (
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/soffa-io/soffa-core-go/h"
+	"github.com/soffa-io/soffa-core-go/saga"
+)
+
+// UseSagas registers sagas, their state is stored in the (single) datasource of
+// the application and the step timeouts are checked by the scheduler.
+func (a *App) UseSagas(cb func(m *saga.Manager)) *App {
+	if a.sagas == nil {
+		if a.broker == nil || a.dbManager == nil {
+			errors.RaiseNew("sagas require a broker and a datasource, call UseBroker and UseDB first")
+		}
+		a.sagas = saga.NewManager(a.broker, a.dbManager.GetLink())
+		interval := h.AnyStr(a.cfg.Get("saga.timeout.check", "SAGA_TIMEOUT_CHECK"), "5s")
+		a.scheduler.Every(interval, a.sagas.CheckTimeouts)
+	}
+	cb(a.sagas)
+	return a
+}
@@ -0,0 +1,21 @@
+package soffa
+
+import (
+	"github.com/soffa-io/soffa-core-go/broker"
+	"github.com/soffa-io/soffa-core-go/db"
+	"github.com/soffa-io/soffa-core-go/h"
+	"github.com/soffa-io/soffa-core-go/log"
+)
+
+// publishTenantEvents publishes the tenant lifecycle events of the datasources
+// on the broker, when both are configured.
+func (a *App) publishTenantEvents() {
+	if a.dbManager == nil || a.broker == nil {
+		return
+	}
+	subject := h.AnyStr(a.cfg.Get("tenants.subject", "TENANTS_SUBJECT"), "tenants.lifecycle")
+	a.dbManager.OnTenantEvent(func(event db.TenantEvent) {
+		err := a.broker.Publish(subject, event, broker.WithEvent(event.Type), broker.WithTenant(event.Tenant))
+		log.Default.ErrorIf(err, "unable to publish tenant event")
+	})
+}
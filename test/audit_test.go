@@ -0,0 +1,123 @@
+package test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"github.com/soffa-io/soffa-core-go"
+	"github.com/soffa-io/soffa-core-go/db"
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/soffa-io/soffa-core-go/h"
+	"github.com/soffa-io/soffa-core-go/http"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type Document struct {
+	db.Model
+	Id    string
+	Title string
+}
+
+func newAuditLink(t *testing.T) (*db.Manager, *db.Link) {
+	m := db.NewManager("audit-test")
+	link := m.Add(db.DS{
+		Url: "sqlite:" + filepath.Join(t.TempDir(), "audit.db"),
+		Migrations: []*gormigrate.Migration{{
+			ID: "0001",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&Document{})
+			},
+		}},
+	})
+	m.Migrate()
+	return m, link
+}
+
+func TestAuditModel(t *testing.T) {
+	m, link := newAuditLink(t)
+	defer m.Close()
+
+	doc := Document{Id: "d1", Title: "draft"}
+	link.Principal("alice").Create(&doc)
+	assert.Equal(t, "alice", doc.CreatedBy)
+	assert.Equal(t, "alice", doc.UpdatedBy)
+	assert.Equal(t, int64(1), doc.Version)
+	assert.False(t, doc.CreatedAt.IsZero())
+
+	var stale Document
+	link.FindById(&stale, "d1")
+	doc.Title = "final"
+	link.Principal("bob").Save(&doc)
+	assert.Equal(t, int64(2), doc.Version)
+
+	var saved Document
+	link.FindById(&saved, "d1")
+	assert.Equal(t, "final", saved.Title)
+	assert.Equal(t, "alice", saved.CreatedBy)
+	assert.Equal(t, "bob", saved.UpdatedBy)
+	assert.Equal(t, int64(2), saved.Version)
+
+	// stale was read before the update
+	stale.Title = "lost"
+	assertFunctional(t, errors.ErrConflictCode, func() { link.Save(&stale) })
+	assert.Equal(t, int64(1), stale.Version)
+	assertFunctional(t, errors.ErrConflictCode, func() { db.NewRepository[Document](link).Update(&stale) })
+
+	// the documents never saved are inserted
+	link.Save(&Document{Id: "d2", Title: "other"})
+	assert.Equal(t, int64(2), link.Count(&Document{}, nil))
+
+	db.NewRepository[Document](link).Delete("d1")
+	var docs []Document
+	link.Find(&docs, db.Q())
+	assert.Equal(t, 1, len(docs))
+	assert.Equal(t, int64(1), link.Count(&Document{}, nil))
+	assert.False(t, link.ExistsBy(&Document{}, "id = ?", "d1"))
+	var deleted int64
+	link.Raw(&deleted, "SELECT COUNT(*) FROM documents WHERE deleted_at IS NOT NULL")
+	assert.Equal(t, int64(1), deleted)
+}
+
+func TestAuditPrincipal(t *testing.T) {
+	t.Setenv("JWT_SECRET", "audit-secret")
+	app := newBrokerApp("audit-test")
+	var link *db.Link
+	app.UseDB(func(m *db.Manager) {
+		link = m.Add(db.DS{
+			Url: "sqlite:" + filepath.Join(t.TempDir(), "audit.db"),
+			Migrations: []*gormigrate.Migration{{
+				ID: "0001",
+				Migrate: func(tx *gorm.DB) error {
+					return tx.AutoMigrate(&Document{})
+				},
+			}},
+		})
+	})
+	app.Configure(func(router *http.Router, scheduler *soffa.Scheduler) {
+		router.Use(&http.JwtBearerFilter{Secret: "audit-secret"})
+		router.PUT("/documents", func(c *http.Context) {
+			var doc Document
+			if c.BindJson(&doc) {
+				db.LinkFrom(c.Ctx(), link).Save(&doc)
+				c.OK(doc)
+			}
+		})
+	})
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	tester.PUT("/documents", h.Map{"Id": "d1", "Title": "draft"}).WithJwtBearer("alice", "").
+		Expect().OK().Json("$.createdBy").Is("alice")
+	tester.PUT("/documents", h.Map{"Id": "d1", "Title": "final", "version": 1}).WithJwtBearer("bob", "").
+		Expect().OK().Json("$.version").Is(2)
+	tester.PUT("/documents", h.Map{"Id": "d1", "Title": "lost", "version": 1}).WithJwtBearer("bob", "").
+		Expect().Conflict()
+
+	var doc Document
+	link.FindById(&doc, "d1")
+	assert.Equal(t, "final", doc.Title)
+	assert.Equal(t, "alice", doc.CreatedBy)
+	assert.Equal(t, "bob", doc.UpdatedBy)
+}
@@ -0,0 +1,168 @@
+package test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/soffa-io/soffa-core-go"
+	"github.com/soffa-io/soffa-core-go/broker"
+	"github.com/soffa-io/soffa-core-go/broker/brokertest"
+	"github.com/soffa-io/soffa-core-go/conf"
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/soffa-io/soffa-core-go/http"
+	"github.com/soffa-io/soffa-core-go/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func newJetStreamApp(t *testing.T, mode string) *soffa.App {
+	app, _ := newJetStreamAppWith(t, mode)
+	return app
+}
+
+func newJetStreamAppWith(t *testing.T, mode string) (*soffa.App, *brokertest.EmbeddedServer) {
+	srv := brokertest.NewEmbeddedJetStreamBroker(t)
+	t.Setenv("BROKER_STREAM", "ORDERS")
+	t.Setenv("BROKER_STREAM_SUBJECTS", "orders.>")
+	t.Setenv("BROKER_CONSUMER_MODE", mode)
+	t.Setenv("BROKER_ACK_WAIT", "1s")
+	log.Application = "js-test"
+	app := soffa.NewApp(conf.New("test"), "js-test", "1.0")
+	app.Configure(func(router *http.Router, scheduler *soffa.Scheduler) {})
+	return app, srv
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("condition not met in time")
+}
+
+func testJetStreamRedelivery(t *testing.T, mode string) {
+	app := newJetStreamApp(t, mode)
+	redelivered := broker.RedeliveryCounter.Value("orders.created")
+	var calls int32
+	app.UseBroker(func(client broker.Client) {
+		// published before the subscription exists, the stream keeps it
+		assert.Nil(t, client.Publish("orders.created", "order-1"))
+		client.Subscribe("orders.created", func(msg broker.Message) interface{} {
+			var id string
+			assert.Nil(t, msg.Decode(&id))
+			assert.Equal(t, "order-1", id)
+			if atomic.AddInt32(&calls, 1) == 1 {
+				panic(errors.New("temporary failure"))
+			}
+			return nil
+		})
+	})
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	waitFor(t, func() bool { return atomic.LoadInt32(&calls) == 2 })
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Equal(t, redelivered+1, broker.RedeliveryCounter.Value("orders.created"))
+}
+
+func TestJetStreamPushRedelivery(t *testing.T) {
+	testJetStreamRedelivery(t, broker.PushConsumer)
+}
+
+func TestJetStreamPullRedelivery(t *testing.T) {
+	testJetStreamRedelivery(t, broker.PullConsumer)
+}
+
+func TestJetStreamTerminatesFunctionalErrors(t *testing.T) {
+	app := newJetStreamApp(t, broker.PushConsumer)
+	var calls int32
+	app.UseBroker(func(client broker.Client) {
+		client.Subscribe("orders.cancelled", func(msg broker.Message) interface{} {
+			atomic.AddInt32(&calls, 1)
+			panic(errors.NewFunctionalError("order.invalid", "order cannot be cancelled"))
+		})
+	})
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	assert.Nil(t, tester.Publish("orders.cancelled", "order-2"))
+	waitFor(t, func() bool { return atomic.LoadInt32(&calls) == 1 })
+	time.Sleep(1500 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestJetStreamDeadLetters(t *testing.T) {
+	app := newJetStreamApp(t, broker.PushConsumer)
+	var calls int32
+	var healthy int32
+	var dlq broker.DeadLetterQueue
+	app.UseBroker(func(client broker.Client) {
+		dlq = client.DeadLetters()
+		policy := broker.DefaultRetryPolicy()
+		policy.InitialDelay = 10 * time.Millisecond
+		client.Subscribe("orders.shipped", func(msg broker.Message) interface{} {
+			atomic.AddInt32(&calls, 1)
+			if atomic.LoadInt32(&healthy) == 0 {
+				panic(errors.New("carrier unavailable"))
+			}
+			return nil
+		}, broker.WithRetry(policy))
+	})
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	assert.Nil(t, tester.Publish("orders.shipped", "order-3"))
+	waitFor(t, func() bool { return atomic.LoadInt32(&calls) == 3 })
+
+	var letters []broker.DeadLetter
+	waitFor(t, func() bool {
+		letters, _ = dlq.List("orders.>")
+		return len(letters) == 1
+	})
+	assert.Equal(t, "orders.shipped", letters[0].Message.Subject)
+	assert.Equal(t, 3, letters[0].Attempts)
+	assert.Contains(t, letters[0].Error, "carrier unavailable")
+
+	atomic.StoreInt32(&healthy, 1)
+	assert.Nil(t, dlq.Replay(letters[0].ID))
+	waitFor(t, func() bool { return atomic.LoadInt32(&calls) == 4 })
+	letters, _ = dlq.List("")
+	assert.Empty(t, letters)
+}
+
+func TestJetStreamStreamCreatedAfterStart(t *testing.T) {
+	app, srv := newJetStreamAppWith(t, broker.PushConsumer)
+	app.UseBroker(func(client broker.Client) {})
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	// another service creates its stream once the application is running
+	conn, err := nats.Connect(srv.Url())
+	assert.Nil(t, err)
+	defer conn.Close()
+	js, err := conn.JetStream()
+	assert.Nil(t, err)
+	_, err = js.AddStream(&nats.StreamConfig{Name: "INVOICES", Subjects: []string{"invoices.>"}})
+	assert.Nil(t, err)
+	assert.Nil(t, tester.Publish("invoices.created", "invoice-1"))
+
+	// the stream kept the message published before the subscription
+	received := make(chan string, 1)
+	tester.Subscribe("invoices.created", func(msg broker.Message) interface{} {
+		var id string
+		_ = msg.Decode(&id)
+		received <- id
+		return nil
+	})
+	select {
+	case id := <-received:
+		assert.Equal(t, "invoice-1", id)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message of the new stream not received")
+	}
+}
@@ -0,0 +1,59 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/soffa-io/soffa-core-go"
+	"github.com/soffa-io/soffa-core-go/broker"
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrokerMetrics(t *testing.T) {
+	t.Setenv("BROKER_URL", "mock")
+	app := newBrokerApp("metrics-test")
+	var inFlight float64
+	var client broker.Client
+	app.UseBroker(func(c broker.Client) {
+		client = c
+		client.Subscribe("metrics.>", func(msg broker.Message) interface{} {
+			inFlight = broker.InFlightGauge.Value("metrics.>")
+			if msg.Subject == "metrics.fail" {
+				errors.RaiseFunctional("rejected")
+			}
+			return "pong"
+		})
+		client.Subscribe("slowmetrics.ping", func(msg broker.Message) interface{} {
+			time.Sleep(100 * time.Millisecond)
+			return "pong"
+		})
+	})
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	handled := func(outcome string) int64 {
+		return broker.MessagesCounter.Value("metrics.>", broker.OpHandle, outcome)
+	}
+	success, functional := handled(broker.OutcomeSuccess), handled(broker.OutcomeFunctional)
+	latencies := broker.HandleLatency.Count("metrics.>", broker.OutcomeSuccess)
+	published := broker.MessagesCounter.Value("metrics.ok", broker.OpPublish, broker.OutcomeSuccess)
+	timeouts := broker.RequestLatency.Count("slowmetrics.ping", broker.OutcomeTimeout)
+
+	assert.Nil(t, tester.Publish("metrics.ok", "ping"))
+	assert.NotNil(t, client.Request("metrics.fail", "ping", nil))
+	assert.NotNil(t, client.Request("slowmetrics.ping", "ping", nil, broker.WithTimeout(10*time.Millisecond)))
+
+	assert.Equal(t, float64(1), inFlight)
+	assert.Equal(t, float64(0), broker.InFlightGauge.Value("metrics.>"))
+	assert.Equal(t, success+1, handled(broker.OutcomeSuccess))
+	assert.Equal(t, functional+1, handled(broker.OutcomeFunctional))
+	assert.Equal(t, latencies+1, broker.HandleLatency.Count("metrics.>", broker.OutcomeSuccess))
+	assert.Equal(t, published+1, broker.MessagesCounter.Value("metrics.ok", broker.OpPublish, broker.OutcomeSuccess))
+	assert.Equal(t, timeouts+1, broker.RequestLatency.Count("slowmetrics.ping", broker.OutcomeTimeout))
+
+	tester.GET("/metrics").Expect().OK().
+		Contains(`x_sys_broker_messages{operation="handle",outcome="success",subject="metrics.>"}`).
+		Contains(`x_sys_broker_handle_seconds_bucket{outcome="success",subject="metrics.>"`).
+		Contains(`x_sys_broker_in_flight{subject="metrics.>"} 0`)
+}
@@ -0,0 +1,334 @@
+package test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/soffa-io/soffa-core-go"
+	"github.com/soffa-io/soffa-core-go/broker"
+	"github.com/soffa-io/soffa-core-go/broker/brokertest"
+	"github.com/soffa-io/soffa-core-go/conf"
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/soffa-io/soffa-core-go/http"
+	"github.com/soffa-io/soffa-core-go/log"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func newNatsApp(t *testing.T) (*soffa.App, *brokertest.EmbeddedServer) {
+	srv := brokertest.NewEmbeddedBroker(t)
+	t.Setenv("BROKER_RECONNECT_WAIT", "100ms")
+	t.Setenv("BROKER_MAX_RECONNECTS", "-1")
+	app := newBrokerApp("nats-test")
+	app.UseBroker(func(client broker.Client) {})
+	return app, srv
+}
+
+func newBrokerApp(name string) *soffa.App {
+	log.Application = name
+	app := soffa.NewApp(conf.New("test"), name, "1.0")
+	app.Configure(func(router *http.Router, scheduler *soffa.Scheduler) {})
+	return app
+}
+
+func TestNatsConnectionHealth(t *testing.T) {
+	app, srv := newNatsApp(t)
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	disconnects := broker.DisconnectCounter.Total()
+	reconnects := broker.ReconnectCounter.Total()
+
+	tester.GET("/health/ready").Expect().OK()
+
+	srv.Disconnect(t, tester.Broker())
+	tester.GET("/health/ready").Expect().Status(503).Json("$.components.broker.status").Is("DOWN")
+	assert.Equal(t, disconnects+1, broker.DisconnectCounter.Total())
+
+	srv.Reconnect(t, tester.Broker())
+	tester.GET("/health/ready").Expect().OK()
+	assert.Equal(t, reconnects+1, broker.ReconnectCounter.Total())
+}
+
+func testMessageEnvelope(t *testing.T, app *soffa.App) {
+	received := make(chan broker.Message, 1)
+	app.UseBroker(func(client broker.Client) {
+		client.Subscribe("invoices.created", func(msg broker.Message) interface{} {
+			received <- msg
+			return nil
+		})
+	})
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	assert.Nil(t, tester.Publish("invoices.created", "invoice-1",
+		broker.WithEvent("InvoiceCreated"),
+		broker.WithTenant("acme"),
+		broker.WithCorrelationId("corr-1"),
+		broker.WithHeader("X-User", "john"),
+	))
+
+	var msg broker.Message
+	select {
+	case msg = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+	assert.NotEmpty(t, msg.ID)
+	assert.Equal(t, "invoices.created", msg.Subject)
+	assert.Equal(t, "InvoiceCreated", msg.Event)
+	assert.Equal(t, "envelope-test", msg.Source)
+	assert.Equal(t, "acme", msg.TenantId)
+	assert.Equal(t, "corr-1", msg.CorrelationId)
+	assert.Equal(t, "john", msg.Header("X-User"))
+	assert.False(t, msg.Timestamp.IsZero())
+	var data string
+	assert.Nil(t, msg.Decode(&data))
+	assert.Equal(t, "invoice-1", data)
+}
+
+func TestNatsMessageEnvelope(t *testing.T) {
+	brokertest.NewEmbeddedBroker(t)
+	app := newBrokerApp("envelope-test")
+	testMessageEnvelope(t, app)
+}
+
+func TestMockMessageEnvelope(t *testing.T) {
+	t.Setenv("BROKER_URL", "mock")
+	app := newBrokerApp("envelope-test")
+	testMessageEnvelope(t, app)
+}
+
+type invoice struct {
+	Id     string
+	Amount int
+}
+
+func TestNatsCodecs(t *testing.T) {
+	brokertest.NewEmbeddedBroker(t)
+	app := newBrokerApp("codec-test")
+	received := make(chan broker.Message, 2)
+	var client broker.Client
+	app.UseBroker(func(c broker.Client) {
+		client = c
+		c.UseCodec(broker.Protobuf, "proto.>")
+		c.Subscribe("json.invoice", func(msg broker.Message) interface{} {
+			received <- msg
+			return nil
+		})
+		c.Subscribe("proto.name", func(msg broker.Message) interface{} {
+			received <- msg
+			return nil
+		})
+		c.Subscribe("rpc.invoice", func(msg broker.Message) interface{} {
+			var in invoice
+			errors.Raise(msg.Decode(&in))
+			in.Amount *= 2
+			return in
+		})
+	})
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	assert.Nil(t, client.Publish("json.invoice", invoice{Id: "inv-1", Amount: 10}))
+	msg := <-received
+	assert.Equal(t, "application/json", msg.ContentType)
+	assert.JSONEq(t, `{"Id":"inv-1","Amount":10}`, string(msg.Data))
+
+	assert.Nil(t, client.Publish("proto.name", wrapperspb.String("soffa")))
+	msg = <-received
+	assert.Equal(t, "application/x-protobuf", msg.ContentType)
+	var name wrapperspb.StringValue
+	assert.Nil(t, msg.Decode(&name))
+	assert.Equal(t, "soffa", name.Value)
+
+	var out invoice
+	assert.Nil(t, client.Request("rpc.invoice", invoice{Id: "inv-2", Amount: 21}, &out, broker.WithCodec(broker.Gob)))
+	assert.Equal(t, invoice{Id: "inv-2", Amount: 42}, out)
+}
+
+func testQueueGroups(t *testing.T, app *soffa.App) {
+	var replica1, replica2, audit int32
+	app.UseBroker(func(client broker.Client) {
+		client.SubscribeQueue("payments.received", "", func(msg broker.Message) interface{} {
+			atomic.AddInt32(&replica1, 1)
+			return nil
+		})
+		client.SubscribeQueue("payments.received", "", func(msg broker.Message) interface{} {
+			atomic.AddInt32(&replica2, 1)
+			return nil
+		})
+		client.SubscribeQueue("payments.received", "audit", func(msg broker.Message) interface{} {
+			atomic.AddInt32(&audit, 1)
+			return nil
+		})
+	})
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, tester.Publish("payments.received", i))
+	}
+	waitFor(t, func() bool {
+		return atomic.LoadInt32(&replica1)+atomic.LoadInt32(&replica2) == 20 && atomic.LoadInt32(&audit) == 20
+	})
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(20), atomic.LoadInt32(&replica1)+atomic.LoadInt32(&replica2))
+	assert.Equal(t, int32(20), atomic.LoadInt32(&audit))
+}
+
+func TestNatsQueueGroups(t *testing.T) {
+	brokertest.NewEmbeddedBroker(t)
+	app := newBrokerApp("queue-test")
+	testQueueGroups(t, app)
+}
+
+func TestMockQueueGroups(t *testing.T) {
+	t.Setenv("BROKER_URL", "mock")
+	app := newBrokerApp("queue-test")
+	testQueueGroups(t, app)
+}
+
+func TestJetStreamQueueGroups(t *testing.T) {
+	brokertest.NewEmbeddedJetStreamBroker(t)
+	t.Setenv("BROKER_STREAM_SUBJECTS", "payments.>")
+	app := newBrokerApp("queue-test")
+	testQueueGroups(t, app)
+}
+
+func TestMockSubjectSemantics(t *testing.T) {
+	t.Setenv("BROKER_URL", "mock")
+	app := newBrokerApp("mock-test")
+	var exact, star, all, other int32
+	app.UseBroker(func(client broker.Client) {
+		client.Subscribe("users.created", func(msg broker.Message) interface{} {
+			atomic.AddInt32(&exact, 1)
+			return nil
+		})
+		client.Subscribe("users.*", func(msg broker.Message) interface{} {
+			atomic.AddInt32(&star, 1)
+			return nil
+		})
+		client.Subscribe("users.>", func(msg broker.Message) interface{} {
+			atomic.AddInt32(&all, 1)
+			return nil
+		})
+		client.Subscribe("accounts.*", func(msg broker.Message) interface{} {
+			atomic.AddInt32(&other, 1)
+			return nil
+		})
+	})
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	assert.Nil(t, tester.Publish("users.created", "u1", broker.WithEvent("UserCreated")))
+	assert.Nil(t, tester.Publish("users.created", "u2"))
+	assert.Nil(t, tester.Publish("users.profile.updated", "u1"))
+
+	assert.Equal(t, int32(2), exact)
+	assert.Equal(t, int32(2), star)
+	assert.Equal(t, int32(3), all)
+	assert.Equal(t, int32(0), other)
+
+	published := tester.AssertPublished("users.created", 2)
+	assert.Equal(t, "UserCreated", published[0].Event)
+	var id string
+	assert.Nil(t, published[1].Decode(&id))
+	assert.Equal(t, "u2", id)
+	tester.AssertPublished("users.>", 3)
+	tester.AssertPublished("accounts.*", 0)
+}
+
+func testRequestReplies(t *testing.T, app *soffa.App) {
+	var client broker.Client
+	app.UseBroker(func(c broker.Client) {
+		client = c
+		c.Subscribe("orders.validate", func(msg broker.Message) interface{} {
+			var id string
+			errors.Raise(msg.Decode(&id))
+			if id == "" {
+				panic(errors.NewFunctionalError("order.missing", "order id is required"))
+			}
+			return "valid:" + id
+		})
+		c.Subscribe("orders.slow", func(msg broker.Message) interface{} {
+			time.Sleep(500 * time.Millisecond)
+			return "done"
+		})
+	})
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	var out string
+	assert.Nil(t, client.RequestCtx(context.Background(), "orders.validate", "o-1", &out))
+	assert.Equal(t, "valid:o-1", out)
+
+	err := client.Request("orders.validate", "", &out)
+	var functional errors.ErrFunctional
+	assert.True(t, errors.As(err, &functional))
+	assert.Equal(t, "order.missing", functional.Code)
+	assert.Contains(t, err.Error(), "order id is required")
+
+	err = client.Request("orders.slow", "", &out, broker.WithTimeout(100*time.Millisecond))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = client.RequestCtx(ctx, "orders.slow", "", &out)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NotNil(t, client.PublishCtx(cancelled, "orders.slow", ""))
+}
+
+func TestNatsRequestReplies(t *testing.T) {
+	brokertest.NewEmbeddedBroker(t)
+	testRequestReplies(t, newBrokerApp("request-test"))
+}
+
+func TestMockRequestReplies(t *testing.T) {
+	t.Setenv("BROKER_URL", "mock")
+	testRequestReplies(t, newBrokerApp("request-test"))
+}
+
+func TestNatsRetryDoesNotBlockSubscription(t *testing.T) {
+	app, _ := newNatsApp(t)
+	var dlq broker.DeadLetterQueue
+	handled := make(chan string, 10)
+	var failures int32
+	app.UseBroker(func(client broker.Client) {
+		dlq = client.DeadLetters()
+		policy := broker.DefaultRetryPolicy()
+		policy.InitialDelay = 300 * time.Millisecond
+		client.Subscribe("parcels.sent", func(msg broker.Message) interface{} {
+			var id string
+			_ = msg.Decode(&id)
+			if id == "parcel-1" && atomic.AddInt32(&failures, 1) < 3 {
+				// a returned error fails the message like a panic
+				return errors.New("carrier unavailable")
+			}
+			handled <- id
+			return nil
+		}, broker.WithRetry(policy))
+	})
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	assert.Nil(t, tester.Publish("parcels.sent", "parcel-1"))
+	assert.Nil(t, tester.Publish("parcels.sent", "parcel-2"))
+
+	// the backoff of parcel-1 does not hold the next message
+	assert.Equal(t, "parcel-2", <-handled)
+	select {
+	case id := <-handled:
+		assert.Equal(t, "parcel-1", id)
+	case <-time.After(5 * time.Second):
+		t.Fatal("parcel-1 was not redelivered")
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&failures))
+	letters, _ := dlq.List("")
+	assert.Empty(t, letters)
+}
@@ -0,0 +1,72 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/soffa-io/soffa-core-go"
+	"github.com/soffa-io/soffa-core-go/broker"
+	"github.com/soffa-io/soffa-core-go/broker/brokertest"
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type createOrder struct {
+	Customer string `binding:"required"`
+	Quantity int    `binding:"min=1"`
+}
+
+type orderCreated struct {
+	Id       string
+	Customer string
+}
+
+func testTypedHandlers(t *testing.T, app *soffa.App) {
+	var client broker.Client
+	events := make(chan orderCreated, 1)
+	app.UseBroker(func(c broker.Client) {
+		client = c
+		broker.On(c, "orders.create", func(ctx context.Context, cmd createOrder) (*orderCreated, error) {
+			msg, ok := broker.MessageFrom(ctx)
+			assert.True(t, ok)
+			assert.Equal(t, "orders.create", msg.Subject)
+			if cmd.Customer == "blocked" {
+				return nil, errors.NewFunctionalError("customer.blocked", "customer is blocked")
+			}
+			return &orderCreated{Id: "o-1", Customer: cmd.Customer}, nil
+		})
+		broker.OnEvent(c, "orders.created", func(ctx context.Context, event orderCreated) error {
+			events <- event
+			return nil
+		})
+	})
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	var created orderCreated
+	assert.Nil(t, client.Request("orders.create", createOrder{Customer: "acme", Quantity: 2}, &created))
+	assert.Equal(t, orderCreated{Id: "o-1", Customer: "acme"}, created)
+
+	var functional errors.ErrFunctional
+	err := client.Request("orders.create", createOrder{Customer: "acme"}, &created)
+	assert.True(t, errors.As(err, &functional))
+	assert.Equal(t, broker.ErrValidationCode, functional.Code)
+	assert.Contains(t, err.Error(), "Quantity")
+
+	err = client.Request("orders.create", createOrder{Customer: "blocked", Quantity: 1}, &created)
+	assert.True(t, errors.As(err, &functional))
+	assert.Equal(t, "customer.blocked", functional.Code)
+
+	assert.Nil(t, client.Publish("orders.created", created))
+	assert.Equal(t, created, <-events)
+}
+
+func TestNatsTypedHandlers(t *testing.T) {
+	brokertest.NewEmbeddedBroker(t)
+	testTypedHandlers(t, newBrokerApp("typed-test"))
+}
+
+func TestMockTypedHandlers(t *testing.T) {
+	t.Setenv("BROKER_URL", "mock")
+	testTypedHandlers(t, newBrokerApp("typed-test"))
+}
@@ -0,0 +1,90 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/soffa-io/soffa-core-go/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialects(t *testing.T) {
+	// no server listens there, the dialects are accepted and fail to connect
+	for _, url := range []string{
+		"mysql://u:p@127.0.0.1:1/app",
+		"mariadb://u:p@127.0.0.1:1/app",
+		"sqlserver://u:p@127.0.0.1:1/app",
+	} {
+		err := panicOf(func() {
+			db.NewManager("dialect-test").Add(db.DS{Url: url})
+		})
+		assert.Contains(t, err, "failed", url)
+		assert.NotContains(t, err, "Unsupported database dialect", url)
+	}
+	assert.Contains(t, panicOf(func() {
+		db.NewManager("dialect-test").Add(db.DS{Url: "oracle://u:p@127.0.0.1:1/app"})
+	}), "Unsupported database dialect")
+}
+
+func TestSqliteTenants(t *testing.T) {
+	m := db.NewManager("dialect-test")
+	link := m.Add(db.DS{
+		Url:           "sqlite:" + filepath.Join(t.TempDir(), "tenants.db"),
+		Migrations:    productMigrations,
+		TenantsLoader: func() []string { return []string{"t1", "t2"} },
+	})
+	m.Migrate()
+	defer m.Close()
+
+	// sqlite has no tenancy, the tenants share the database
+	link.Tenant("t1").Create(&Product{Id: "p1", Name: "pen"})
+	assert.True(t, link.Tenant("t2").ExistsById(&Product{}, "p1"))
+	link.Tenant("t2").Truncate(&Product{})
+	assert.Equal(t, int64(0), link.Tenant("t1").Count(&Product{}, nil))
+}
+
+func panicOf(fn func()) (message string) {
+	defer func() {
+		if r := recover(); r != nil {
+			message = fmt.Sprint(r)
+		}
+	}()
+	fn()
+	return ""
+}
+
+// serverUrl returns the url of a database server for the tests that need one,
+// from env (e.g. TEST_POSTGRES_URL), they are skipped when it is not set. The
+// user must not be a superuser so that the row level security applies.
+func serverUrl(t *testing.T, env string) string {
+	url := os.Getenv(env)
+	if url == "" {
+		t.Skipf("%s is not set", env)
+	}
+	return url
+}
+
+// uniqueName prefixes the tables and tenants of a test on a shared server.
+func uniqueName(prefix string) string {
+	return fmt.Sprintf("%s%d_", prefix, time.Now().UnixNano())
+}
+
+func TestMysqlUpdateWithSameValues(t *testing.T) {
+	m := db.NewManager("dialect-test")
+	link := m.Add(db.DS{
+		Url:         serverUrl(t, "TEST_MYSQL_URL"),
+		TablePrefix: uniqueName("upd"),
+		Migrations:  productMigrations,
+	})
+	m.Migrate()
+	defer m.Close()
+
+	repo := db.NewRepository[Product](link)
+	product := Product{Id: "p1", Name: "pen", Price: 2}
+	repo.Insert(&product)
+	assert.NotPanics(t, func() { repo.Update(&product) })
+	assertNotFound(t, func() { repo.Update(&Product{Id: "p9"}) })
+}
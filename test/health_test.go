@@ -0,0 +1,83 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/soffa-io/soffa-core-go"
+	"github.com/soffa-io/soffa-core-go/conf"
+	"github.com/soffa-io/soffa-core-go/db"
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/soffa-io/soffa-core-go/http"
+	"github.com/soffa-io/soffa-core-go/log"
+)
+
+func newHealthApp(check func(ctx context.Context) error) *soffa.App {
+	log.Application = "health-test"
+	app := soffa.NewApp(conf.New("test"), "health-test", "1.0")
+	app.Configure(func(router *http.Router, scheduler *soffa.Scheduler) {})
+	app.AddHealthContributor(soffa.NewHealthContributor("downstream", 100*time.Millisecond, check))
+	return app
+}
+
+func TestHealthProbes(t *testing.T) {
+	app := newHealthApp(func(ctx context.Context) error {
+		return nil
+	})
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	tester.GET("/health/live").Expect().OK()
+	tester.GET("/health/startup").Expect().OK()
+	tester.GET("/health/ready").Expect().OK().Json("$.components.downstream.status").Is("UP")
+}
+
+func TestHealthProbesWithFailingContributor(t *testing.T) {
+	app := newHealthApp(func(ctx context.Context) error {
+		return errors.New("connection refused")
+	})
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	tester.GET("/health/live").Expect().OK()
+	tester.GET("/health/ready").Expect().Status(503).Json("$.components.downstream.message").Is("connection refused")
+	tester.GET("/healthz").Expect().Status(503).Json("$.status").Is("DOWN")
+}
+
+func TestHealthProbesTimeout(t *testing.T) {
+	app := newHealthApp(func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(time.Second)
+		return nil
+	})
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	tester.GET("/health/ready").Expect().Status(503).Json("$.components.downstream.status").Is("DOWN")
+}
+
+type fakeTimeSeries struct {
+	err error
+}
+
+func (f fakeTimeSeries) Save(entries []db.TimeSerieEntry) {}
+
+func (f fakeTimeSeries) Ping(ctx context.Context) error {
+	return f.err
+}
+
+type noPingTimeSeries struct{}
+
+func (noPingTimeSeries) Save(entries []db.TimeSerieEntry) {}
+
+func TestTimeSeriesHealthContributor(t *testing.T) {
+	app := newHealthApp(func(ctx context.Context) error { return nil })
+	app.AddHealthContributor(soffa.NewTimeSeriesHealthContributor("influxdb", fakeTimeSeries{err: errors.New("influxdb is unhealthy")}))
+	app.AddHealthContributor(soffa.NewTimeSeriesHealthContributor("metrics", noPingTimeSeries{}))
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	tester.GET("/health/ready").Expect().Status(503).Json("$.components.influxdb.message").Is("influxdb is unhealthy")
+	tester.GET("/health/ready").Expect().Status(503).Json("$.components.metrics.status").Is("UP")
+}
@@ -0,0 +1,82 @@
+package test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"github.com/soffa-io/soffa-core-go"
+	"github.com/soffa-io/soffa-core-go/broker"
+	"github.com/soffa-io/soffa-core-go/db"
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/soffa-io/soffa-core-go/h"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestInboxSkipsDuplicates(t *testing.T) {
+	t.Setenv("BROKER_URL", "mock")
+	app := newBrokerApp("inbox-test")
+	var link *db.Link
+	app.UseDB(func(m *db.Manager) {
+		link = m.Add(db.DS{
+			Url:   "sqlite:" + filepath.Join(t.TempDir(), "inbox.db"),
+			Inbox: true,
+			Migrations: []*gormigrate.Migration{{
+				ID: "0001",
+				Migrate: func(tx *gorm.DB) error {
+					return tx.AutoMigrate(&Invoice{})
+				},
+			}},
+		})
+	})
+	calls := 0
+	app.UseBroker(func(client broker.Client) {
+		client.Subscribe("payments.charged", func(msg broker.Message) interface{} {
+			calls++
+			var invoice Invoice
+			errors.Raise(msg.Decode(&invoice))
+			db.LinkFrom(msg.Context(), link).Create(&invoice)
+			if invoice.Amount < 0 {
+				errors.RaiseNew("invalid amount")
+			}
+			return nil
+		}, broker.WithInbox(db.NewInbox(link, time.Hour)))
+	})
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	assert.Nil(t, tester.Publish("payments.charged", Invoice{Id: "inv-1", Amount: 10}, broker.WithMessageId("msg-1")))
+	assert.Nil(t, tester.Publish("payments.charged", Invoice{Id: "inv-1", Amount: 10}, broker.WithMessageId("msg-1")))
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, int64(1), link.Count(&Invoice{}, nil))
+
+	// the failure rolls the handler writes back, the message can be processed again
+	assert.Nil(t, tester.Publish("payments.charged", Invoice{Id: "inv-2", Amount: -1}, broker.WithMessageId("msg-2")))
+	assert.Equal(t, 2, calls)
+	assert.False(t, link.ExistsById(&Invoice{}, "inv-2"))
+	assert.False(t, link.ExistsBy(&db.InboxMessage{}, "message_id = ?", "msg-2"))
+}
+
+func TestMemoryInboxExpiresEntries(t *testing.T) {
+	t.Setenv("BROKER_URL", "mock")
+	app := newBrokerApp("inbox-test")
+	calls := 0
+	app.UseBroker(func(client broker.Client) {
+		client.Subscribe("payments.refunded", func(msg broker.Message) interface{} {
+			calls++
+			return nil
+		}, broker.WithInbox(broker.NewMemoryInbox(50*time.Millisecond)))
+	})
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	id := h.NewUniqueId()
+	assert.Nil(t, tester.Publish("payments.refunded", "r-1", broker.WithMessageId(id)))
+	assert.Nil(t, tester.Publish("payments.refunded", "r-1", broker.WithMessageId(id)))
+	assert.Equal(t, 1, calls)
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, tester.Publish("payments.refunded", "r-1", broker.WithMessageId(id)))
+	assert.Equal(t, 2, calls)
+}
@@ -0,0 +1,129 @@
+package test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"github.com/soffa-io/soffa-core-go/db"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type Note struct {
+	db.TenantModel
+	Id   string
+	Text string
+}
+
+func TestRowIsolation(t *testing.T) {
+	m := db.NewManager("isolation-test")
+	link := m.Add(db.DS{
+		Url:            "sqlite:" + filepath.Join(t.TempDir(), "isolation.db"),
+		Isolation:      db.IsolationRow,
+		TenantRegistry: true,
+		Migrations: []*gormigrate.Migration{{
+			ID: "0001",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&Note{})
+			},
+		}},
+	})
+	m.Migrate()
+	defer m.Close()
+	link.ProvisionTenant("acme")
+	link.ProvisionTenant("globex")
+
+	acme, globex := link.Tenant("acme"), link.Tenant("globex")
+	acme.Create(&Note{Id: "n1", Text: "acme"})
+	globex.Create(&[]Note{{Id: "n2", Text: "globex"}, {Id: "n3", Text: "globex"}})
+
+	var notes []Note
+	acme.Find(&notes, db.Q())
+	assert.Equal(t, 1, len(notes))
+	assert.Equal(t, "acme", notes[0].TenantId)
+	assert.Equal(t, int64(2), globex.Count(&Note{}, nil))
+	assert.False(t, acme.ExistsById(&Note{}, "n2"))
+	assert.True(t, globex.ExistsById(&Note{}, "n2"))
+
+	repo := db.NewRepository[Note](link)
+	assert.Equal(t, "acme", repo.Tenant("acme").Get("n1").Text)
+	assertNotFound(t, func() { repo.Tenant("acme").Get("n2") })
+	note := repo.Tenant("globex").Get("n2")
+	note.Text = "updated"
+	repo.Tenant("globex").Update(&note)
+	assertNotFound(t, func() { repo.Tenant("acme").Update(&note) })
+	assertNotFound(t, func() { repo.Tenant("acme").Delete("n3") })
+	assert.Equal(t, "updated", repo.Tenant("globex").Get("n2").Text)
+
+	acme.Truncate(&Note{})
+	assert.Equal(t, int64(0), acme.Count(&Note{}, nil))
+	assert.Equal(t, int64(2), globex.Count(&Note{}, nil))
+	assertTechnical(t, db.ErrTenantRequiredCode, func() { link.Count(&Note{}, nil) })
+	// raw sql can't be filtered by tenant
+	var count int64
+	assert.Panics(t, func() { acme.Raw(&count, "SELECT COUNT(*) FROM notes") })
+	assert.Panics(t, func() { acme.Exec("DELETE FROM notes") })
+	assert.Equal(t, int64(2), globex.Count(&Note{}, nil))
+
+	link.SuspendTenant("globex")
+	link.DropTenant("globex")
+	link.ProvisionTenant("globex")
+	assert.Equal(t, int64(0), link.Tenant("globex").Count(&Note{}, nil))
+}
+
+func TestRowLevelSecurityRequiresPostgres(t *testing.T) {
+	assert.Panics(t, func() {
+		db.NewManager("isolation-test").Add(db.DS{
+			Url:              "sqlite:" + filepath.Join(t.TempDir(), "rls.db"),
+			Isolation:        db.IsolationRow,
+			RowLevelSecurity: true,
+		})
+	})
+	m := db.NewManager("isolation-test")
+	m.Add(db.DS{
+		Url: "sqlite:" + filepath.Join(t.TempDir(), "rls.db"),
+		Migrations: []*gormigrate.Migration{{
+			ID: "0001",
+			Migrate: func(tx *gorm.DB) error {
+				return db.EnableRowLevelSecurity(tx, &Note{})
+			},
+		}},
+	})
+	defer m.Close()
+	assert.Panics(t, m.Migrate)
+}
+
+func TestRowLevelSecurityDropTenant(t *testing.T) {
+	prefix := uniqueName("rls")
+	m := db.NewManager("isolation-test")
+	link := m.Add(db.DS{
+		Url:              serverUrl(t, "TEST_POSTGRES_URL"),
+		TablePrefix:      prefix,
+		Isolation:        db.IsolationRow,
+		RowLevelSecurity: true,
+		TenantRegistry:   true,
+		Migrations: []*gormigrate.Migration{{
+			ID: "0001",
+			Migrate: func(tx *gorm.DB) error {
+				if err := tx.AutoMigrate(&Note{}); err != nil {
+					return err
+				}
+				return db.EnableRowLevelSecurity(tx, &Note{})
+			},
+		}},
+	})
+	m.Migrate()
+	defer m.Close()
+	acme, globex := prefix+"acme", prefix+"globex"
+	link.ProvisionTenant(acme)
+	link.ProvisionTenant(globex)
+	link.Tenant(acme).Create(&[]Note{{Id: "n1"}, {Id: "n2"}})
+	link.Tenant(globex).Create(&Note{Id: "n3"})
+
+	link.SuspendTenant(acme)
+	link.DropTenant(acme)
+	link.ProvisionTenant(acme)
+	assert.Equal(t, int64(0), link.Tenant(acme).Count(&Note{}, nil))
+	assert.Equal(t, int64(1), link.Tenant(globex).Count(&Note{}, nil))
+}
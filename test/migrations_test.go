@@ -0,0 +1,111 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"github.com/soffa-io/soffa-core-go/db"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type Tag struct {
+	Id   string
+	Name string
+}
+
+func TestMigrations(t *testing.T) {
+	files := fstest.MapFS{
+		"migrations/0002_tags.up.sql":        {Data: []byte("CREATE TABLE tags (id TEXT PRIMARY KEY, name TEXT)")},
+		"migrations/0002_tags.down.sql":      {Data: []byte("DROP TABLE tags")},
+		"migrations/0003_tag_index.up.sql":   {Data: []byte("CREATE INDEX idx_tags_name ON tags (name)")},
+		"migrations/0003_tag_index.down.sql": {Data: []byte("DROP INDEX idx_tags_name")},
+		"migrations/README.md":               {Data: []byte("ignored")},
+	}
+	m := db.NewManager("migrations-test")
+	link := m.Add(db.DS{
+		Url:            "sqlite:" + filepath.Join(t.TempDir(), "migrations.db"),
+		Migrations:     productMigrations,
+		MigrationFiles: files,
+	})
+	defer m.Close()
+
+	pending := m.DryRun()
+	assert.Equal(t, 3, len(pending))
+	assert.Equal(t, "0001", pending[0].Id)
+	assert.Empty(t, pending[0].Sql)
+	assert.Equal(t, "0002_tags", pending[1].Id)
+	assert.Contains(t, pending[1].Sql, "CREATE TABLE tags")
+	assert.Equal(t, 3, len(m.DryRun()), "dry-run must not apply anything")
+
+	m.Migrate()
+	assert.Empty(t, m.DryRun())
+	link.Create(&Tag{Id: "t1", Name: "new"})
+	for _, s := range m.Status() {
+		assert.Equal(t, "primary", s.Datasource)
+		assert.True(t, s.Applied, s.Id)
+	}
+
+	m.Rollback("", "")
+	status := m.Status()
+	assert.Equal(t, 3, len(status))
+	assert.True(t, status[1].Applied)
+	assert.False(t, status[2].Applied)
+
+	m.Rollback("", "0001")
+	assert.Equal(t, []string{"0002_tags", "0003_tag_index"}, pendingIds(m.DryRun()))
+	assert.Panics(t, func() { link.Count(&Tag{}, nil) })
+	assert.Panics(t, func() { m.Rollback("", "0009") })
+	assert.Panics(t, func() { m.Rollback("unknown", "") })
+}
+
+func TestRollbackKeepsBuiltinMigrations(t *testing.T) {
+	m := db.NewManager("rollback-test")
+	link := m.Add(db.DS{
+		Url: "sqlite:" + filepath.Join(t.TempDir(), "rollback.db"),
+		Migrations: []*gormigrate.Migration{{
+			ID: "0001_tags",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&Tag{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&Tag{})
+			},
+		}},
+		Outbox: true,
+		Inbox:  true,
+	})
+	defer m.Close()
+	m.Migrate()
+
+	m.Rollback("", "")
+	assert.Equal(t, []string{"0001_tags"}, pendingIds(m.DryRun()))
+	assert.Equal(t, int64(0), link.Count(&db.OutboxEvent{}, nil))
+	assert.Equal(t, int64(0), link.Count(&db.InboxMessage{}, nil))
+	// nothing left to roll back
+	m.Rollback("", "")
+	assert.Panics(t, func() { m.Rollback("", "soffa_outbox_0001") })
+	assert.Equal(t, int64(0), link.Count(&db.OutboxEvent{}, nil))
+}
+
+func TestCreateMigrationFiles(t *testing.T) {
+	dir := t.TempDir()
+	up, down := db.CreateMigrationFiles(dir, "Add Tags!")
+	assert.True(t, strings.HasSuffix(up, "_add_tags.up.sql"))
+	assert.True(t, strings.HasSuffix(down, "_add_tags.down.sql"))
+	_, err := os.Stat(up)
+	assert.Nil(t, err)
+	assert.Panics(t, func() { db.CreateMigrationFiles(dir, "!!") })
+}
+
+func pendingIds(pending []db.PendingMigration) []string {
+	var ids []string
+	for _, m := range pending {
+		ids = append(ids, m.Id)
+	}
+	return ids
+}
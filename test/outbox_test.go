@@ -0,0 +1,93 @@
+package test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"github.com/soffa-io/soffa-core-go"
+	"github.com/soffa-io/soffa-core-go/broker"
+	"github.com/soffa-io/soffa-core-go/db"
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type Invoice struct {
+	Id     string `gorm:"primaryKey"`
+	Amount int
+}
+
+func newOutboxApp(t *testing.T) *soffa.App {
+	t.Setenv("BROKER_URL", "mock")
+	t.Setenv("OUTBOX_INTERVAL", "1h")
+	app := newBrokerApp("outbox-test")
+	app.UseBroker(func(client broker.Client) {})
+	app.UseDB(func(m *db.Manager) {
+		m.Add(db.DS{
+			Url:    "sqlite:" + filepath.Join(t.TempDir(), "outbox.db"),
+			Outbox: true,
+			Migrations: []*gormigrate.Migration{{
+				ID: "0001",
+				Migrate: func(tx *gorm.DB) error {
+					return tx.AutoMigrate(&Invoice{})
+				},
+			}},
+		})
+	})
+	return app
+}
+
+func TestOutboxPublishesCommittedEvents(t *testing.T) {
+	app := newOutboxApp(t)
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+	var received []broker.Message
+	tester.Subscribe("invoices.>", func(msg broker.Message) interface{} {
+		received = append(received, msg)
+		return nil
+	})
+
+	link := tester.DB().GetLink()
+	var eventId string
+	link.Transactional(func(tx *db.Link) {
+		tx.Create(&Invoice{Id: "inv-1", Amount: 10})
+		eventId = tx.PublishEvent("invoices.created", Invoice{Id: "inv-1", Amount: 10}, map[string]string{"X-User": "john"})
+	})
+
+	assert.Panics(t, func() {
+		link.Transactional(func(tx *db.Link) {
+			tx.PublishEvent("invoices.created", Invoice{Id: "inv-2"})
+			errors.RaiseNew("rollback")
+		})
+	})
+
+	assert.Equal(t, 1, tester.FlushOutbox())
+	assert.Equal(t, 0, tester.FlushOutbox())
+	assert.Len(t, received, 1)
+	assert.Equal(t, eventId, received[0].ID)
+	assert.Equal(t, "john", received[0].Header("X-User"))
+	var invoice Invoice
+	assert.Nil(t, received[0].Decode(&invoice))
+	assert.Equal(t, Invoice{Id: "inv-1", Amount: 10}, invoice)
+}
+
+func TestOutboxRetriesFailedEvents(t *testing.T) {
+	app := newOutboxApp(t)
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	link := tester.DB().GetLink()
+	// nobody listens on this subject yet, the mock broker rejects the message
+	id := link.PublishEvent("invoices.paid", Invoice{Id: "inv-3"})
+	failures := db.OutboxRelayCounter.Errors()
+	assert.Equal(t, 0, tester.FlushOutbox())
+	assert.Equal(t, failures+1, db.OutboxRelayCounter.Errors())
+
+	var event db.OutboxEvent
+	assert.True(t, link.FindById(&event, id))
+	assert.Equal(t, db.OutboxPending, event.Status)
+	assert.Equal(t, 1, event.Attempts)
+	assert.Contains(t, event.LastError, "subject not found")
+	assert.True(t, event.NextAttemptAt.After(event.CreatedAt))
+}
@@ -0,0 +1,41 @@
+package test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/soffa-io/soffa-core-go/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadReplicas(t *testing.T) {
+	dir := t.TempDir()
+	replicaUrl := "sqlite:" + filepath.Join(dir, "replica.db")
+	// stands for the replication of the schema
+	replica := newRepositoryLinkAt(t, replicaUrl)
+	defer replica.Close()
+
+	m := db.NewManager("replica-test")
+	link := m.Add(db.DS{
+		Url:        "sqlite:" + filepath.Join(dir, "primary.db"),
+		Replicas:   []string{replicaUrl},
+		Migrations: productMigrations,
+		Pool:       db.Pool{MaxOpen: 3, MaxIdle: 2, MaxLifetime: time.Minute},
+	})
+	m.Migrate()
+	defer m.Close()
+
+	link.Create(&Product{Id: "p1", Name: "pen"})
+	assert.False(t, link.ExistsById(&Product{}, "p1"))
+	assert.True(t, link.Primary().ExistsById(&Product{}, "p1"))
+	link.Transactional(func(tx *db.Link) {
+		assert.True(t, tx.ExistsById(&Product{}, "p1"))
+	})
+	assert.Equal(t, int64(0), db.NewRepository[Product](link).Count(nil))
+	assert.Equal(t, int64(1), db.NewRepository[Product](link.Primary()).Count(nil))
+
+	assert.Equal(t, float64(3), db.PoolMaxOpenGauge.Value("replica_test", "primary", "primary"))
+	assert.Equal(t, float64(3), db.PoolMaxOpenGauge.Value("replica_test", "primary", "replica-0"))
+	assert.True(t, db.PoolOpenGauge.Value("replica_test", "primary", "primary") >= 1)
+}
@@ -0,0 +1,243 @@
+package test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"github.com/soffa-io/soffa-core-go"
+	"github.com/soffa-io/soffa-core-go/conf"
+	"github.com/soffa-io/soffa-core-go/db"
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/soffa-io/soffa-core-go/h"
+	"github.com/soffa-io/soffa-core-go/http"
+	"github.com/soffa-io/soffa-core-go/log"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type Category struct {
+	Id   string `gorm:"primaryKey"`
+	Name string
+}
+
+type Product struct {
+	Id         string `gorm:"primaryKey"`
+	Name       string
+	Price      int
+	CategoryId *string
+	Category   *Category
+}
+
+var productMigrations = []*gormigrate.Migration{{
+	ID: "0001",
+	Migrate: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&Category{}, &Product{})
+	},
+}}
+
+func newRepositoryLink(t *testing.T) *db.Link {
+	return newRepositoryLinkAt(t, "sqlite:"+filepath.Join(t.TempDir(), "repository.db"))
+}
+
+func newRepositoryLinkAt(t *testing.T, url string) *db.Link {
+	m := db.NewManager("repository-test")
+	link := m.Add(db.DS{Url: url, Migrations: productMigrations})
+	m.Migrate()
+	return link
+}
+
+func assertNotFound(t *testing.T, fn func()) {
+	defer func() {
+		err, _ := recover().(error)
+		var functional errors.ErrFunctional
+		assert.True(t, errors.As(err, &functional), "expected a functional error, got %v", err)
+		assert.Equal(t, errors.ErrNotFoundCode, functional.Code)
+	}()
+	fn()
+}
+
+func testRepository(t *testing.T, repo db.Repository[Product]) {
+	for _, p := range []Product{{Id: "p1", Name: "pen", Price: 2}, {Id: "p2", Name: "book", Price: 12}, {Id: "p3", Name: "bag", Price: 30}, {Id: "p4", Name: "pen", Price: 3}} {
+		p := p
+		repo.Insert(&p)
+	}
+
+	assert.Equal(t, "book", repo.Get("p2").Name)
+	assertNotFound(t, func() { repo.Get("p9") })
+	assert.True(t, repo.Exists("p1"))
+	assert.False(t, repo.Exists("p9"))
+	assert.Equal(t, int64(4), repo.Count(nil))
+	assert.Equal(t, int64(2), repo.Count(db.Q().W(h.Map{"name": "pen"})))
+
+	pens := repo.FindAll(db.Q().W(h.Map{"name": "pen"}).Sort("price desc"))
+	assert.Len(t, pens, 2)
+	assert.Equal(t, "p4", pens[0].Id)
+
+	page := repo.Page(db.Q().Sort("price"), 1, 3)
+	assert.Len(t, page.Items, 1)
+	assert.Equal(t, "p3", page.Items[0].Id)
+	assert.Equal(t, int64(4), page.Total)
+	assert.False(t, page.HasNext)
+	page = repo.Page(db.Q().Where(db.Gt("price", 2)).Sort("price"), 0, 2)
+	assert.Len(t, page.Items, 2)
+	assert.Equal(t, int64(3), page.Total)
+	assert.True(t, page.HasNext)
+
+	// sorted by name desc, then by id
+	var walked []string
+	cursor := ""
+	for i := 0; i < 3; i++ {
+		page := repo.Cursor(db.Q().Sort("-name"), cursor, 3)
+		for _, p := range page.Items {
+			walked = append(walked, p.Id)
+		}
+		if !page.HasNext {
+			break
+		}
+		cursor = page.Next
+	}
+	assert.Equal(t, []string{"p1", "p4", "p2", "p3"}, walked)
+	assert.Panics(t, func() { repo.Cursor(nil, "not-a-cursor", 3) })
+	assertFunctional(t, "FVAL", func() { repo.Cursor(nil, "", 0) })
+	assertFunctional(t, "FVAL", func() { repo.Page(nil, 0, -1) })
+
+	ids := func(products []Product) []string {
+		var out []string
+		for _, p := range products {
+			out = append(out, p.Id)
+		}
+		return out
+	}
+	// nil compares like in sql
+	assert.Equal(t, int64(4), repo.Count(db.Q().Where(db.Eq("category_id", nil))))
+	assert.Equal(t, int64(0), repo.Count(db.Q().Where(db.Ne("category_id", nil))))
+	assert.Equal(t, int64(0), repo.Count(db.Q().Where(db.Gt("price", nil))))
+	assert.Equal(t, int64(1), repo.Count(db.Q().Where(db.In("id", nil, "p1"))))
+	assert.Equal(t, []string{"p3", "p1"}, ids(repo.FindAll(db.Q().Where(db.Or(db.Gt("price", 20), db.Lt("price", 3))).Sort("-price"))))
+	assert.Equal(t, []string{"p2", "p4"}, ids(repo.FindAll(db.Q().Where(db.In("id", "p2", "p4", "p9")))))
+	assert.Equal(t, []string{"p2", "p3"}, ids(repo.FindAll(db.Q().Where(db.Like("name", "b%")).Sort("name desc"))))
+	assert.Equal(t, []string{"p1", "p4"}, ids(repo.FindAll(db.Q().Where(db.ILike("name", "PE_")))))
+	assert.Equal(t, []string{"p4", "p2"}, ids(repo.FindAll(db.Q().Where(db.Between("price", 3, 12), db.Ne("name", "bag")).Sort("price"))))
+	assert.Equal(t, []string{"p4", "p1", "p2"}, ids(repo.FindAll(db.Q().Where(db.And(db.IsNull("category_id"), db.Lte("price", 12))).Sort("name desc", "price desc"))))
+	assert.Equal(t, int64(0), repo.Count(db.Q().Where(db.NotNull("category_id"))))
+	assert.Equal(t, int64(3), repo.Count(db.Q().Where(db.Gte("price", 3))))
+
+	p := repo.Get("p1")
+	p.Price = 0
+	repo.Update(&p)
+	assert.Equal(t, 0, repo.Get("p1").Price)
+	assertNotFound(t, func() { repo.Update(&Product{Id: "p9"}) })
+
+	repo.Delete("p1")
+	assert.False(t, repo.Exists("p1"))
+	assertNotFound(t, func() { repo.Delete("p1") })
+}
+
+func TestRepository(t *testing.T) {
+	link := newRepositoryLink(t)
+	repo := db.NewRepository[Product](link)
+	testRepository(t, repo)
+
+	assert.Panics(t, func() {
+		link.Transactional(func(tx *db.Link) {
+			repo.With(tx).Insert(&Product{Id: "p5", Name: "cup"})
+			assert.True(t, repo.With(tx).Exists("p5"))
+			errors.RaiseNew("rollback")
+		})
+	})
+	assert.False(t, repo.Exists("p5"))
+}
+
+func TestQueryBuilder(t *testing.T) {
+	link := newRepositoryLink(t)
+	stationery := "c1"
+	link.Create(&Category{Id: stationery, Name: "stationery"})
+	link.Create(&Product{Id: "p1", Name: "pen", Price: 2, CategoryId: &stationery})
+	link.Create(&Product{Id: "p2", Name: "book", Price: 12})
+
+	var products []Product
+	link.Find(&products, db.Q().Joins("Category").Where(db.Eq("Category.name", "stationery")))
+	assert.Len(t, products, 1)
+	assert.Equal(t, "stationery", products[0].Category.Name)
+
+	products = nil
+	link.Find(&products, db.Q().Preload("Category").Select("id", "name", "category_id").Sort("name"))
+	assert.Len(t, products, 2)
+	assert.Equal(t, 0, products[0].Price)
+	assert.Nil(t, products[0].Category)
+	assert.Equal(t, "stationery", products[1].Category.Name)
+
+	assert.True(t, link.Exists(&Product{}, db.Q().Where(db.Eq("name", "pen"), db.NotNull("category_id"))))
+	assert.False(t, link.Exists(&Product{}, db.Q().Where(db.Eq("name", "book"), db.NotNull("category_id"))))
+	assert.True(t, link.ExistsBy(&Product{}, "price > ? OR name = ?", 10, "x"))
+
+	products = nil
+	link.Find(&products, db.Q().Sort("name").Page(1, 1))
+	assert.Len(t, products, 1)
+	assert.Equal(t, "pen", products[0].Name)
+
+	// W and Wheres replace their previous condition, AndW and AndWheres add one
+	assert.Equal(t, int64(1), link.Count(&Product{}, db.Q().W(h.Map{"name": "x"}).W(h.Map{"name": "pen"})))
+	assert.Equal(t, int64(0), link.Count(&Product{}, db.Q().W(h.Map{"name": "pen"}).AndW(h.Map{"price": 12})))
+	assert.Equal(t, int64(1), link.Count(&Product{}, db.Q().Wheres("price > ?", 100).Wheres("price > ?", 10)))
+	assert.Equal(t, int64(0), link.Count(&Product{}, db.Q().Wheres("price > ?", 10).AndWheres("name = ?", "pen")))
+
+	assert.Equal(t, int64(1), link.Count(&Product{}, db.Q().Where(db.Eq(`"name"`, "pen"))))
+	assert.NotPanics(t, func() { db.Eq("main.products.name", "pen") })
+	assertFunctional(t, db.ErrInvalidColumnCode, func() { db.Q().Sort("name; DROP TABLE products") })
+}
+
+func TestMemoryRepository(t *testing.T) {
+	repo := db.NewMemoryRepository[Product]()
+	testRepository(t, repo)
+
+	tenant := repo.Tenant("t1")
+	tenant.Insert(&Product{Id: "p5", Name: "cup"})
+	assert.True(t, tenant.Exists("p5"))
+	assert.False(t, repo.Exists("p5"))
+	assert.False(t, repo.Tenant("t2").Exists("p5"))
+}
+
+func TestPaginationEndpoints(t *testing.T) {
+	repo := db.NewMemoryRepository[Product](
+		Product{Id: "p1", Name: "pen", Price: 2}, Product{Id: "p2", Name: "book", Price: 12},
+		Product{Id: "p3", Name: "bag", Price: 30}, Product{Id: "p4", Name: "pen", Price: 3},
+	)
+	log.Application = "pagination-test"
+	app := soffa.NewApp(conf.New("test"), "pagination-test", "1.0")
+	app.Configure(func(router *http.Router, scheduler *soffa.Scheduler) {
+		router.GET("/products", func(c *http.Context) {
+			p := c.Pagination()
+			c.OKPage(repo.Page(db.Q().Sort(p.Sort), p.Page, p.Size))
+		})
+		router.GET("/products/feed", func(c *http.Context) {
+			p := c.Pagination()
+			c.OKCursor(repo.Cursor(db.Q().Sort(p.Sort), p.Cursor, p.Size))
+		})
+	})
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	res := tester.GET("/products").Query("page", 1).Query("size", 2).Query("sort", "-price").Expect().OK()
+	res.Json("$.items[0].Id").Is("p4")
+	res.Json("$.total").Is(4)
+	res.Json("$.hasNext").Is(false)
+	assert.Equal(t, "4", res.Header("X-Total-Count"))
+	assert.Equal(t, `</products?page=0&size=2&sort=-price>; rel="first", </products?page=0&size=2&sort=-price>; rel="prev", </products?page=1&size=2&sort=-price>; rel="last"`, res.Header("Link"))
+
+	tester.GET("/products").Query("size", 1000).Expect().BadRequest()
+	tester.GET("/products").Query("page", "x").Expect().BadRequest()
+	tester.GET("/products").Query("sort", "price;").Expect().BadRequest()
+
+	res = tester.GET("/products/feed").Query("size", 3).Query("sort", "price").Expect().OK()
+	res.Json("$.items[2].Id").Is("p2")
+	next := res.Json("$.next").String()
+	assert.Contains(t, res.Header("Link"), "cursor="+next)
+	res = tester.GET("/products/feed").Query("size", 3).Query("sort", "price").Query("cursor", next).Expect().OK()
+	res.Json("$.items").IsArrayWithLength(1)
+	res.Json("$.items[0].Id").Is("p3")
+	assert.Equal(t, "", res.Header("Link"))
+	tester.GET("/products/feed").Query("cursor", "x").Expect().BadRequest()
+	tester.GET("/products/feed").Query("size", 0).Expect().BadRequest()
+}
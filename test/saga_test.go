@@ -0,0 +1,246 @@
+package test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"github.com/soffa-io/soffa-core-go"
+	"github.com/soffa-io/soffa-core-go/broker"
+	"github.com/soffa-io/soffa-core-go/db"
+	"github.com/soffa-io/soffa-core-go/saga"
+	"github.com/stretchr/testify/assert"
+)
+
+type SagaOrder struct {
+	Id      string
+	Amount  int
+	Charged bool
+}
+
+func orderSaga(shippingTimeout time.Duration) saga.Definition {
+	return saga.Definition{
+		Name:    "order",
+		StartOn: "orders.created",
+		Steps: []saga.Step{
+			{
+				Name: "payment",
+				Action: func(ctx *saga.Context) {
+					var order SagaOrder
+					_ = ctx.Data(&order)
+					ctx.Publish("payments.charge", order)
+				},
+				On: map[string]func(ctx *saga.Context, msg broker.Message){
+					"payments.charged": func(ctx *saga.Context, msg broker.Message) {
+						var order SagaOrder
+						_ = ctx.Data(&order)
+						order.Charged = true
+						ctx.SetData(order)
+						ctx.Goto("shipping")
+					},
+					"payments.declined": func(ctx *saga.Context, msg broker.Message) {
+						ctx.Fail("payment declined")
+					},
+				},
+				Compensate: func(ctx *saga.Context) {
+					var order SagaOrder
+					_ = ctx.Data(&order)
+					ctx.Publish("payments.refund", order)
+				},
+			},
+			{
+				Name:    "shipping",
+				Timeout: shippingTimeout,
+				Action: func(ctx *saga.Context) {
+					ctx.Publish("shipping.schedule", nil)
+				},
+				On: map[string]func(ctx *saga.Context, msg broker.Message){
+					"shipping.scheduled": func(ctx *saga.Context, msg broker.Message) {
+						ctx.Complete()
+					},
+				},
+			},
+		},
+	}
+}
+
+func newSagaTester(t *testing.T, def saga.Definition, shipping bool) (soffa.Tester, *saga.Manager) {
+	return newSagaTesterWith(t, def, shipping, db.DS{})
+}
+
+func newSagaTesterWith(t *testing.T, def saga.Definition, shipping bool, ds db.DS) (soffa.Tester, *saga.Manager) {
+	t.Setenv("BROKER_URL", "mock")
+	app := newBrokerApp("saga-test")
+	app.UseBroker(func(client broker.Client) {})
+	app.UseDB(func(m *db.Manager) {
+		ds.Url = "sqlite:" + filepath.Join(t.TempDir(), "saga.db")
+		ds.Migrations = []*gormigrate.Migration{saga.Migration()}
+		m.Add(ds)
+	})
+	var manager *saga.Manager
+	app.UseSagas(func(m *saga.Manager) {
+		manager = m.Register(def)
+	})
+	tester := soffa.NewTester(t, app)
+
+	tester.Subscribe("payments.charge", func(msg broker.Message) interface{} {
+		var order SagaOrder
+		_ = msg.Decode(&order)
+		if order.Amount > 100 {
+			_ = tester.Publish("payments.declined", order, broker.CausedBy(msg))
+		} else {
+			_ = tester.Publish("payments.charged", order, broker.CausedBy(msg))
+		}
+		return nil
+	})
+	tester.Subscribe("payments.refund", func(msg broker.Message) interface{} {
+		return nil
+	})
+	tester.Subscribe("shipping.schedule", func(msg broker.Message) interface{} {
+		if shipping {
+			_ = tester.Publish("shipping.scheduled", nil, broker.CausedBy(msg))
+		}
+		return nil
+	})
+	return tester, manager
+}
+
+func TestSagaCompletes(t *testing.T) {
+	tester, manager := newSagaTester(t, orderSaga(time.Minute), true)
+	defer tester.Close()
+
+	order := SagaOrder{Id: "order-1", Amount: 10}
+	assert.Nil(t, tester.Publish("orders.created", order, broker.WithMessageId("order-1")))
+
+	state, found := manager.Get("order", "order-1")
+	assert.True(t, found)
+	assert.Equal(t, saga.StatusCompleted, state.Status)
+	assert.Equal(t, "shipping", state.Step)
+	assert.Nil(t, state.Deadline)
+
+	charge := tester.AssertPublished("payments.charge", 1)
+	assert.Equal(t, "order-1", charge[0].CorrelationId)
+	assert.Equal(t, "order-1", charge[0].CausationId)
+	scheduled := tester.AssertPublished("shipping.scheduled", 1)
+	assert.Equal(t, "order-1", scheduled[0].CorrelationId)
+	tester.AssertPublished("payments.refund", 0)
+
+	// a redelivered start message does not restart the saga
+	assert.Nil(t, tester.Publish("orders.created", order, broker.WithMessageId("order-1")))
+	tester.AssertPublished("payments.charge", 1)
+}
+
+func TestSagaFailureAndTimeoutCompensation(t *testing.T) {
+	tester, manager := newSagaTester(t, orderSaga(10*time.Millisecond), false)
+	defer tester.Close()
+
+	assert.Nil(t, tester.Publish("orders.created", SagaOrder{Id: "order-1", Amount: 500}, broker.WithMessageId("order-1")))
+	state, _ := manager.Get("order", "order-1")
+	assert.Equal(t, saga.StatusCompensated, state.Status)
+	assert.Equal(t, "payment declined", state.Error)
+	// the payment step did not complete, there is nothing to refund
+	tester.AssertPublished("payments.refund", 0)
+
+	assert.Nil(t, tester.Publish("orders.created", SagaOrder{Id: "order-2", Amount: 10}, broker.WithMessageId("order-2")))
+	state, _ = manager.Get("order", "order-2")
+	assert.Equal(t, saga.StatusRunning, state.Status)
+	assert.Equal(t, "shipping", state.Step)
+
+	manager.CheckTimeouts()
+	state, _ = manager.Get("order", "order-2")
+	assert.Equal(t, saga.StatusRunning, state.Status)
+
+	time.Sleep(20 * time.Millisecond)
+	manager.CheckTimeouts()
+	state, _ = manager.Get("order", "order-2")
+	assert.Equal(t, saga.StatusCompensated, state.Status)
+	assert.Equal(t, "step shipping timed out", state.Error)
+	refund := tester.AssertPublished("payments.refund", 1)
+	assert.Equal(t, "order-2", refund[0].CorrelationId)
+
+	// late events are ignored once the saga is over
+	assert.Nil(t, tester.Publish("shipping.scheduled", nil, broker.WithCorrelationId("order-2")))
+	state, _ = manager.Get("order", "order-2")
+	assert.Equal(t, saga.StatusCompensated, state.Status)
+}
+
+func TestSagaTenants(t *testing.T) {
+	tester, manager := newSagaTesterWith(t, orderSaga(10*time.Millisecond), false, db.DS{TenantRegistry: true})
+	defer tester.Close()
+	link := tester.DB().GetLink()
+	link.ProvisionTenant("acme")
+	link.ProvisionTenant("globex")
+
+	order := SagaOrder{Id: "order-1", Amount: 10}
+	assert.Nil(t, tester.Publish("orders.created", order, broker.WithMessageId("order-1"), broker.WithTenant("acme")))
+	var state saga.State
+	assert.True(t, link.Tenant("acme").FindById(&state, "order-1"))
+	assert.Equal(t, "shipping", state.Step)
+	charge := tester.AssertPublished("payments.charge", 1)
+	assert.Equal(t, "acme", charge[0].TenantId)
+
+	time.Sleep(20 * time.Millisecond)
+	manager.CheckTimeouts()
+	link.Tenant("acme").FindById(&state, "order-1")
+	assert.Equal(t, saga.StatusCompensated, state.Status)
+	assert.Equal(t, "acme", tester.AssertPublished("payments.refund", 1)[0].TenantId)
+
+	// the datasource requires a tenant, the saga is not started
+	assert.Nil(t, tester.Publish("orders.created", SagaOrder{Id: "order-2"}, broker.WithMessageId("order-2")))
+	tester.AssertPublished("payments.charge", 1)
+}
+
+func TestSagaFailingCompensation(t *testing.T) {
+	def := orderSaga(10 * time.Millisecond)
+	def.Steps[0].Compensate = func(ctx *saga.Context) {
+		ctx.Publish("payments.refund", nil)
+		panic("payment service unavailable")
+	}
+	def.Steps = append([]saga.Step{{
+		Name: "stock",
+		Action: func(ctx *saga.Context) {
+			ctx.Goto("payment")
+		},
+		Compensate: func(ctx *saga.Context) {
+			ctx.Publish("stock.release", nil)
+		},
+	}}, def.Steps...)
+	tester, manager := newSagaTester(t, def, false)
+	defer tester.Close()
+
+	assert.Nil(t, tester.Publish("orders.created", SagaOrder{Id: "order-1", Amount: 10}, broker.WithMessageId("order-1")))
+	time.Sleep(20 * time.Millisecond)
+	manager.CheckTimeouts()
+
+	state, _ := manager.Get("order", "order-1")
+	assert.Equal(t, saga.StatusFailed, state.Status)
+	assert.Equal(t, "step shipping timed out (compensation failed: payment: payment service unavailable)", state.Error)
+	// the other compensations still run, the messages of the failed one are dropped
+	tester.AssertPublished("stock.release", 1)
+	tester.AssertPublished("payments.refund", 0)
+}
+
+func TestSagasSharingStartEvent(t *testing.T) {
+	tester, manager := newSagaTester(t, orderSaga(time.Minute), true)
+	defer tester.Close()
+	manager.Register(saga.Definition{
+		Name:    "loyalty",
+		StartOn: "orders.created",
+		Steps: []saga.Step{{
+			Name: "points",
+			Action: func(ctx *saga.Context) {
+				ctx.Publish("loyalty.credit", nil)
+				ctx.Complete()
+			},
+		}},
+	})
+
+	assert.Nil(t, tester.Publish("orders.created", SagaOrder{Id: "order-1", Amount: 10}, broker.WithMessageId("order-1")))
+	order, _ := manager.Get("order", "order-1")
+	assert.Equal(t, saga.StatusCompleted, order.Status)
+	loyalty, found := manager.Get("loyalty", "order-1")
+	assert.True(t, found)
+	assert.Equal(t, saga.StatusCompleted, loyalty.Status)
+	assert.Equal(t, "order-1", tester.AssertPublished("loyalty.credit", 1)[0].CorrelationId)
+}
@@ -0,0 +1,131 @@
+package test
+
+import (
+	"fmt"
+	"net"
+	nethttp "net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/soffa-io/soffa-core-go"
+	"github.com/soffa-io/soffa-core-go/broker"
+	"github.com/soffa-io/soffa-core-go/broker/brokertest"
+	"github.com/soffa-io/soffa-core-go/db"
+	"github.com/soffa-io/soffa-core-go/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdownDrainsInOrder(t *testing.T) {
+	brokertest.NewEmbeddedBroker(t)
+	t.Setenv("OUTBOX_INTERVAL", "20ms")
+	var (
+		mu    sync.Mutex
+		steps []string
+		link  *db.Link
+	)
+	record := func(step string) {
+		// every step uses the datasources, they are closed last
+		link.Count(&Product{}, nil)
+		mu.Lock()
+		steps = append(steps, step)
+		mu.Unlock()
+	}
+	handling := make(chan struct{}, 3)
+
+	app := newBrokerApp("shutdown-test")
+	app.UseDB(func(m *db.Manager) {
+		link = m.Add(db.DS{
+			Url:        "sqlite:" + filepath.Join(t.TempDir(), "shutdown.db"),
+			Migrations: productMigrations,
+			Outbox:     true,
+		})
+	})
+	var router *http.Router
+	var jobOnce sync.Once
+	app.Configure(func(r *http.Router, scheduler *soffa.Scheduler) {
+		router = r
+		r.GET("/slow", func(c *http.Context) {
+			handling <- struct{}{}
+			time.Sleep(100 * time.Millisecond)
+			record("http")
+			c.OK(nil)
+		})
+		scheduler.Every("20ms", func() {
+			jobOnce.Do(func() {
+				handling <- struct{}{}
+				time.Sleep(400 * time.Millisecond)
+				record("job")
+			})
+		})
+	})
+	events := make(chan broker.Message, 1)
+	app.UseBroker(func(client broker.Client) {
+		client.Subscribe("work", func(msg broker.Message) interface{} {
+			handling <- struct{}{}
+			time.Sleep(250 * time.Millisecond)
+			record("broker")
+			return nil
+		})
+		client.Subscribe("products.created", func(msg broker.Message) interface{} {
+			events <- msg
+			return nil
+		})
+	})
+	app.AddShutdownListener(func() {
+		record("listener")
+	})
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	port := freePort(t)
+	router.Listen(port)
+	go router.Serve()
+	url := fmt.Sprintf("http://127.0.0.1:%d", port)
+	assert.Eventually(t, func() bool {
+		res, err := nethttp.Get(url + "/health/live")
+		if err == nil {
+			_ = res.Body.Close()
+		}
+		return err == nil
+	}, 2*time.Second, 20*time.Millisecond)
+
+	link.PublishEvent("products.created", Product{Id: "p1"})
+	assert.Nil(t, tester.Publish("work", ""))
+	status := make(chan int, 1)
+	go func() {
+		res, err := nethttp.Get(url + "/slow")
+		if err != nil {
+			status <- 0
+			return
+		}
+		_ = res.Body.Close()
+		status <- res.StatusCode
+	}()
+	for i := 0; i < 3; i++ {
+		<-handling
+	}
+
+	app.Shutdown()
+	assert.Equal(t, nethttp.StatusOK, <-status, "the in-flight request is drained")
+	assert.Equal(t, []string{"http", "broker", "job", "listener"}, steps)
+	select {
+	case msg := <-events:
+		assert.Equal(t, "products.created", msg.Subject)
+	default:
+		t.Error("the outbox event was not relayed before the broker was closed")
+	}
+	_, err := nethttp.Get(url + "/slow")
+	assert.NotNil(t, err, "the server does not accept requests anymore")
+	assert.NotNil(t, link.Ping(), "the datasources are closed")
+}
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
@@ -0,0 +1,82 @@
+package test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/soffa-io/soffa-core-go"
+	"github.com/soffa-io/soffa-core-go/broker"
+	"github.com/soffa-io/soffa-core-go/db"
+	"github.com/soffa-io/soffa-core-go/h"
+	"github.com/soffa-io/soffa-core-go/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantRouting(t *testing.T) {
+	t.Setenv("BROKER_URL", "mock")
+	t.Setenv("JWT_SECRET", "tenant-routing-secret")
+	t.Setenv("TENANT_STRATEGY", "claim:org,header,path")
+	repo := db.NewMemoryRepository[Product]()
+	repo.Tenant("acme").Insert(&Product{Id: "p1", Name: "pen"})
+	repo.Tenant("globex").Insert(&Product{Id: "p2", Name: "book"})
+
+	app := newBrokerApp("tenant-routing-test")
+	var link *db.Link
+	app.UseDB(func(m *db.Manager) {
+		link = m.Add(db.DS{
+			Url:            "sqlite:" + filepath.Join(t.TempDir(), "routing.db"),
+			Migrations:     productMigrations,
+			TenantRegistry: true,
+		})
+	})
+	app.Configure(func(router *http.Router, scheduler *soffa.Scheduler) {
+		router.Use(&http.JwtBearerFilter{Secret: "tenant-routing-secret"})
+		router.GET("/products", func(c *http.Context) {
+			c.OK(repo.WithContext(c.Ctx()).FindAll(nil))
+		})
+		router.GET("/t/:tenant/count", func(c *http.Context) {
+			c.OK(h.Map{"count": db.LinkFrom(c.Ctx(), link).Count(&Product{}, nil)})
+		})
+		router.GET("/count", func(c *http.Context) {
+			c.OK(h.Map{"count": db.LinkFrom(c.Ctx(), link).Count(&Product{}, nil)})
+		})
+	})
+	var tenants []string
+	var failures []error
+	app.UseBroker(func(client broker.Client) {
+		client.Subscribe("products.count", func(msg broker.Message) interface{} {
+			defer func() {
+				if r := recover(); r != nil {
+					failures = append(failures, r.(error))
+				}
+			}()
+			db.LinkFrom(msg.Context(), link).Count(&Product{}, nil)
+			for _, p := range repo.WithContext(msg.Context()).FindAll(nil) {
+				tenants = append(tenants, p.Id)
+			}
+			return nil
+		})
+	})
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+	link.ProvisionTenant("acme")
+
+	tester.GET("/products").Header("X-Tenant-Id", "acme").Expect().OK().Json("$[0].Id").Is("p1")
+	tester.GET("/products").WithJwtBearerClaims("u1", "", h.Map{"org": "globex"}).
+		Header("X-Tenant-Id", "acme").Expect().OK().Json("$[0].Id").Is("p2")
+	tester.GET("/products").Expect().OK().Json("$").IsEmptyArray()
+
+	tester.GET("/t/acme/count").Expect().OK().Json("$.count").Is(0)
+	// sqlite doesn't build identifiers from the tenants, any id is accepted
+	tester.GET("/t/Acme/count").Expect().OK().Json("$.count").Is(0)
+	// the datasource is multitenant, it is not used without a tenant
+	tester.GET("/count").Expect().Status(500).Json("$.code").Is(db.ErrTenantRequiredCode)
+	assertTechnical(t, db.ErrTenantRequiredCode, func() { link.Count(&Product{}, nil) })
+
+	assert.Nil(t, tester.Publish("products.count", "", broker.WithTenant("acme")))
+	assert.Equal(t, []string{"p1"}, tenants)
+	assert.Empty(t, failures)
+	assert.Nil(t, tester.Publish("products.count", ""))
+	assert.Equal(t, 1, len(failures))
+	assert.Contains(t, failures[0].Error(), "requires a tenant")
+}
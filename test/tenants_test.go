@@ -0,0 +1,123 @@
+package test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/soffa-io/soffa-core-go"
+	"github.com/soffa-io/soffa-core-go/broker"
+	"github.com/soffa-io/soffa-core-go/db"
+	"github.com/soffa-io/soffa-core-go/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantLifecycle(t *testing.T) {
+	t.Setenv("BROKER_URL", "mock")
+	app := newBrokerApp("tenants-test")
+	var link *db.Link
+	app.UseDB(func(m *db.Manager) {
+		link = m.Add(db.DS{
+			Url:            "sqlite:" + filepath.Join(t.TempDir(), "tenants.db"),
+			Migrations:     productMigrations,
+			TenantRegistry: true,
+		})
+	})
+	app.UseBroker(func(client broker.Client) {})
+	tester := soffa.NewTester(t, app)
+	defer tester.Close()
+
+	link.ProvisionTenant("acme", func(tx *db.Link) {
+		tx.Create(&Product{Id: "p1", Name: "pen"})
+	})
+	assert.True(t, link.Tenant("acme").ExistsById(&Product{}, "p1"))
+	assert.Equal(t, "active", link.Tenants()[0].Status)
+	assertFunctional(t, "FVAL", func() { link.ProvisionTenant("acme") })
+
+	events := tester.AssertPublished("tenants.lifecycle", 1)
+	assert.Equal(t, db.TenantProvisionedEvent, events[0].Event)
+	var event db.TenantEvent
+	assert.Nil(t, events[0].Decode(&event))
+	assert.Equal(t, "acme", event.Tenant)
+
+	assert.Panics(t, func() { link.DropTenant("acme") }, "active tenants can't be dropped")
+	link.SuspendTenant("acme")
+	assertFunctional(t, errors.ErrForbiddenCode, func() { link.Tenant("acme").Count(&Product{}, nil) })
+	link.ResumeTenant("acme")
+	assert.Equal(t, int64(1), link.Tenant("acme").Count(&Product{}, nil))
+	tester.AssertPublished("tenants.lifecycle", 3)
+
+	link.SuspendTenant("acme")
+	// sqlite has no schema to drop
+	assert.Panics(t, func() { link.DropTenant("acme") })
+
+	// a failed provisioning is undone, it can be retried
+	assert.Panics(t, func() {
+		link.ProvisionTenant("globex", func(tx *db.Link) {
+			tx.Create(&Product{Id: "p2", Name: "book"})
+			panic("seed failed")
+		})
+	})
+	assert.Len(t, link.Tenants(), 1)
+	link.ProvisionTenant("globex")
+	assert.Equal(t, "active", link.Tenants()[1].Status)
+	assert.False(t, link.Tenant("globex").ExistsById(&Product{}, "p2"))
+}
+
+func TestTenantIds(t *testing.T) {
+	for _, id := range []string{"Acme", "1acme", "acme;drop table products", "acme-corp", "public", ""} {
+		assertFunctional(t, "FVAL", func() { db.ValidateTenantId(id) })
+	}
+	db.ValidateTenantId("acme_corp")
+
+	// sqlite shares the database, no identifier is built from the tenants
+	link := newRepositoryLink(t)
+	assertFunctional(t, "FVAL", func() { link.ProvisionTenant("") })
+	for _, id := range []string{"Acme", "acme-corp", "6f1c2a9e-1b7d-4c3e-9a53-2f0e8d1c4b7a"} {
+		link.ProvisionTenant(id)
+		assert.Equal(t, int64(0), link.Tenant(id).Count(&Product{}, nil))
+	}
+	assert.Empty(t, link.MigrateTenants("t1", "Bad", "bad id"))
+}
+
+func assertFunctional(t *testing.T, code string, fn func()) {
+	defer func() {
+		err, _ := recover().(error)
+		var functional errors.ErrFunctional
+		assert.True(t, errors.As(err, &functional), "expected a functional error, got %v", err)
+		assert.Equal(t, code, functional.Code)
+	}()
+	fn()
+}
+
+func assertTechnical(t *testing.T, code string, fn func()) {
+	defer func() {
+		err, _ := recover().(error)
+		var technical errors.ErrTechnical
+		assert.True(t, errors.As(err, &technical), "expected a technical error, got %v", err)
+		assert.Equal(t, code, technical.Code)
+	}()
+	fn()
+}
+
+func TestPostgresFailedProvisioningDropsSchema(t *testing.T) {
+	m := db.NewManager("tenants-test")
+	link := m.Add(db.DS{
+		Url:            serverUrl(t, "TEST_POSTGRES_URL"),
+		Migrations:     productMigrations,
+		TenantRegistry: true,
+	})
+	m.Migrate()
+	defer m.Close()
+
+	tenant := uniqueName("prov") + "acme"
+	assert.Panics(t, func() {
+		link.ProvisionTenant(tenant, func(tx *db.Link) { panic("seed failed") })
+	})
+	var schemas int64
+	link.Raw(&schemas, "SELECT COUNT(*) FROM information_schema.schemata WHERE schema_name = ?", tenant)
+	assert.Equal(t, int64(0), schemas)
+
+	link.ProvisionTenant(tenant)
+	link.SuspendTenant(tenant)
+	link.DropTenant(tenant)
+}